@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ___________________________________________ Circuit Builder _____________________________
+// Builds circuits in Go instead of parsing them. Words are []Wire slices with the least significant bit first,
+// so InputWord("x", 32) declares the wires x_0 ... x_31 that wireValues("x", X, 32) encodes X into.
+// The x == 9001 && y == 1337 circuit of circuit.v is written as:
+//
+//	b := NewCircuitBuilder()
+//	x, y := b.InputWord("x", 32), b.InputWord("y", 32)
+//	b.Output("out", b.And(b.Equal(x, b.ConstWord(9001, 32)), b.Equal(y, b.ConstWord(1337, 32))))
+//	circuit, inputs, outputs := b.Build()
+//
+// Misusing the builder (unknown gates, words of different widths, reused names) is a programming error and panics.
+
+// Wire is a wire of a circuit being built
+type Wire string
+
+type CircuitBuilder struct {
+	circuit map[string][]string // Map from wire name -> [gate, input wires...]
+	inputs  []string
+	outputs []string
+	drivers map[string]Wire // Output name -> wire driving it
+	next    int             // Counter for fresh wire names
+	zero    Wire            // Shared constant wires, "" until used
+	one     Wire
+}
+
+func NewCircuitBuilder() *CircuitBuilder {
+	return &CircuitBuilder{
+		circuit: make(map[string][]string),
+		drivers: make(map[string]Wire),
+	}
+}
+
+// reserve panics if name is taken by a wire or an output
+func (b *CircuitBuilder) reserve(name string) {
+	_, exists := b.circuit[name]
+	if _, isOutput := b.drivers[name]; exists || isOutput {
+		panic(fmt.Sprintf("circuit builder: wire %s exists already", name))
+	}
+}
+
+// fresh returns an unused wire name _<n>_
+func (b *CircuitBuilder) fresh() string {
+	for {
+		b.next++
+		name := fmt.Sprintf("_%d_", b.next)
+		_, exists := b.circuit[name]
+		if _, isOutput := b.drivers[name]; !exists && !isOutput {
+			return name
+		}
+	}
+}
+
+// Input declares an input wire
+func (b *CircuitBuilder) Input(name string) Wire {
+	b.reserve(name)
+	b.circuit[name] = nil
+	b.inputs = append(b.inputs, name)
+	return Wire(name)
+}
+
+// InputWord declares the input wires prefix_0 ... prefix_<bits-1>
+func (b *CircuitBuilder) InputWord(prefix string, bits int) []Wire {
+	word := make([]Wire, bits)
+	for i := range word {
+		word[i] = b.Input(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	return word
+}
+
+// Output declares an output wire driven by w
+func (b *CircuitBuilder) Output(name string, w Wire) {
+	b.reserve(name)
+	b.drivers[name] = w
+	b.outputs = append(b.outputs, name)
+}
+
+// OutputWord declares the output wires prefix_0 ... driven by the bits of word
+func (b *CircuitBuilder) OutputWord(prefix string, word []Wire) {
+	for i, w := range word {
+		b.Output(fmt.Sprintf("%s_%d", prefix, i), w)
+	}
+}
+
+// Gate adds a gate of a type labelTruthTable supports and returns the wire it drives
+func (b *CircuitBuilder) Gate(gate string, ins ...Wire) Wire {
+	if _, err := gateTruthTable(gate, len(ins)); err != nil {
+		panic("circuit builder: " + err.Error())
+	}
+	out := b.fresh()
+	b.circuit[out] = []string{gate}
+	for _, in := range ins {
+		if _, exists := b.circuit[string(in)]; !exists {
+			panic(fmt.Sprintf("circuit builder: unknown wire %s", in))
+		}
+		b.circuit[out] = append(b.circuit[out], string(in))
+	}
+	return Wire(out)
+}
+
+// Build returns the circuit, inputs and outputs in the form garbleCircuit takes. Gates driving a single
+// output are renamed after it; outputs driven by inputs, constants or other outputs get a buf gate.
+func (b *CircuitBuilder) Build() (map[string][]string, []string, []string) {
+	renames := make(map[string]string) // Gate wire -> output it is renamed after
+	for _, output := range b.outputs {
+		w := string(b.drivers[output])
+		_, renamed := renames[w]
+		if gate := b.circuit[w]; len(gate) > 0 && !renamed && Wire(w) != b.zero && Wire(w) != b.one {
+			renames[w] = output
+		}
+	}
+	rename := func(w string) string {
+		if output, renamed := renames[w]; renamed {
+			return output
+		}
+		return w
+	}
+
+	circuit := make(map[string][]string, len(b.circuit)+len(b.outputs))
+	for wire, gate := range b.circuit {
+		if gate == nil {
+			circuit[wire] = nil
+			continue
+		}
+		renamedGate := []string{gate[0]}
+		for _, in := range gate[1:] {
+			renamedGate = append(renamedGate, rename(in))
+		}
+		circuit[rename(wire)] = renamedGate
+	}
+	for _, output := range b.outputs {
+		if w := string(b.drivers[output]); renames[w] != output {
+			circuit[output] = []string{"buf", rename(w)}
+		}
+	}
+	return circuit, append([]string(nil), b.inputs...), append([]string(nil), b.outputs...)
+}
+
+// ______ Single Bits ______
+
+// Const returns a wire with the constant value bit
+func (b *CircuitBuilder) Const(bit bool) Wire {
+	if bit {
+		if b.one == "" {
+			b.one = b.Gate("const_1")
+		}
+		return b.one
+	}
+	if b.zero == "" {
+		b.zero = b.Gate("const_0")
+	}
+	return b.zero
+}
+
+func (b *CircuitBuilder) And(x, y Wire) Wire  { return b.Gate("and", x, y) }
+func (b *CircuitBuilder) Or(x, y Wire) Wire   { return b.Gate("or", x, y) }
+func (b *CircuitBuilder) Xor(x, y Wire) Wire  { return b.Gate("xor", x, y) }
+func (b *CircuitBuilder) Nand(x, y Wire) Wire { return b.Gate("nand", x, y) }
+func (b *CircuitBuilder) Nor(x, y Wire) Wire  { return b.Gate("nor", x, y) }
+func (b *CircuitBuilder) Xnor(x, y Wire) Wire { return b.Gate("xnor", x, y) }
+func (b *CircuitBuilder) Not(x Wire) Wire     { return b.Gate("not", x) }
+
+// Mux returns x when sel is 0 and y when sel is 1, as x ^ (sel & (x ^ y))
+func (b *CircuitBuilder) Mux(sel, x, y Wire) Wire {
+	return b.Xor(x, b.And(sel, b.Xor(x, y)))
+}
+
+// AndAll returns the AND of all wires as a balanced tree, 1 for no wires
+func (b *CircuitBuilder) AndAll(ws []Wire) Wire {
+	return b.reduce(ws, b.And, true)
+}
+
+// OrAll returns the OR of all wires as a balanced tree, 0 for no wires
+func (b *CircuitBuilder) OrAll(ws []Wire) Wire {
+	return b.reduce(ws, b.Or, false)
+}
+
+// XorAll returns the XOR (parity) of all wires as a balanced tree, 0 for no wires
+func (b *CircuitBuilder) XorAll(ws []Wire) Wire {
+	return b.reduce(ws, b.Xor, false)
+}
+
+func (b *CircuitBuilder) reduce(ws []Wire, op func(x, y Wire) Wire, empty bool) Wire {
+	switch len(ws) {
+	case 0:
+		return b.Const(empty)
+	case 1:
+		return ws[0]
+	}
+	return op(b.reduce(ws[:len(ws)/2], op, empty), b.reduce(ws[len(ws)/2:], op, empty))
+}
+
+// ______ Words ______
+
+// ConstWord returns the bits of value as a word of the given width
+func (b *CircuitBuilder) ConstWord(value uint64, bits int) []Wire {
+	word := make([]Wire, bits)
+	for i := range word {
+		word[i] = b.Const(i < 64 && value>>uint(i)&1 == 1)
+	}
+	return word
+}
+
+// sameWidth panics unless the words have the same width
+func sameWidth(x, y []Wire) {
+	if len(x) != len(y) {
+		panic(fmt.Sprintf("circuit builder: words of %d and %d bits", len(x), len(y)))
+	}
+}
+
+// bitwise applies op to every pair of bits
+func (b *CircuitBuilder) bitwise(x, y []Wire, op func(x, y Wire) Wire) []Wire {
+	sameWidth(x, y)
+	word := make([]Wire, len(x))
+	for i := range word {
+		word[i] = op(x[i], y[i])
+	}
+	return word
+}
+
+func (b *CircuitBuilder) AndWord(x, y []Wire) []Wire { return b.bitwise(x, y, b.And) }
+func (b *CircuitBuilder) OrWord(x, y []Wire) []Wire  { return b.bitwise(x, y, b.Or) }
+func (b *CircuitBuilder) XorWord(x, y []Wire) []Wire { return b.bitwise(x, y, b.Xor) }
+
+func (b *CircuitBuilder) NotWord(x []Wire) []Wire {
+	word := make([]Wire, len(x))
+	for i := range word {
+		word[i] = b.Not(x[i])
+	}
+	return word
+}
+
+// MuxWord returns x when sel is 0 and y when sel is 1
+func (b *CircuitBuilder) MuxWord(sel Wire, x, y []Wire) []Wire {
+	return b.bitwise(x, y, func(x, y Wire) Wire { return b.Mux(sel, x, y) })
+}
+
+// AddCarry returns x + y + carry and the carry out, with one AND gate per bit:
+// the carry out of a full adder is c ^ ((x ^ c) & (y ^ c))
+func (b *CircuitBuilder) AddCarry(x, y []Wire, carry Wire) ([]Wire, Wire) {
+	sameWidth(x, y)
+	sum := make([]Wire, len(x))
+	for i := range sum {
+		xc, yc := b.Xor(x[i], carry), b.Xor(y[i], carry)
+		sum[i] = b.Xor(xc, y[i])
+		carry = b.Xor(carry, b.And(xc, yc))
+	}
+	return sum, carry
+}
+
+// Add returns x + y modulo 2^width
+func (b *CircuitBuilder) Add(x, y []Wire) []Wire {
+	sum, _ := b.AddCarry(x, y, b.Const(false))
+	return sum
+}
+
+// SubBorrow returns x - y and whether it borrowed (x < y), with one AND gate per bit:
+// the borrow out is ~(x ^ ((x ~^ y) & (x ~^ borrow)))
+func (b *CircuitBuilder) SubBorrow(x, y []Wire) ([]Wire, Wire) {
+	sameWidth(x, y)
+	diff := make([]Wire, len(x))
+	borrow := b.Const(false)
+	for i := range diff {
+		diff[i] = b.Xor(b.Xor(x[i], y[i]), borrow)
+		borrow = b.Xnor(x[i], b.And(b.Xnor(x[i], y[i]), b.Xnor(x[i], borrow)))
+	}
+	return diff, borrow
+}
+
+// Sub returns x - y modulo 2^width
+func (b *CircuitBuilder) Sub(x, y []Wire) []Wire {
+	diff, _ := b.SubBorrow(x, y)
+	return diff
+}
+
+// Equal returns whether the words are equal
+func (b *CircuitBuilder) Equal(x, y []Wire) Wire {
+	return b.AndAll(b.bitwise(x, y, b.Xnor))
+}
+
+// LessThan returns whether x < y as unsigned integers
+func (b *CircuitBuilder) LessThan(x, y []Wire) Wire {
+	sameWidth(x, y)
+	borrow := b.Const(false)
+	for i := range x {
+		borrow = b.Xnor(x[i], b.And(b.Xnor(x[i], y[i]), b.Xnor(x[i], borrow)))
+	}
+	return borrow
+}
+
+// Mul returns x * y modulo 2^width, adding the shifted partial products x & y_i
+func (b *CircuitBuilder) Mul(x, y []Wire) []Wire {
+	sameWidth(x, y)
+	product := b.ConstWord(0, len(x))
+	for i := range y {
+		partial := make([]Wire, len(x)-i)
+		for j := range partial {
+			partial[j] = b.And(x[j], y[i])
+		}
+		if i == 0 {
+			copy(product, partial)
+			continue
+		}
+		high := b.Add(product[i:], partial)
+		product = append(product[:i:i], high...)
+	}
+	return product
+}
+
+// ShiftLeft returns x << n, filling with zeros
+func (b *CircuitBuilder) ShiftLeft(x []Wire, n int) []Wire {
+	n = min(n, len(x))
+	word := b.ConstWord(0, len(x))
+	for i := n; i < len(x); i++ {
+		word[i] = x[i-n]
+	}
+	return word
+}
+
+// ShiftRight returns x >> n, filling with zeros
+func (b *CircuitBuilder) ShiftRight(x []Wire, n int) []Wire {
+	n = min(n, len(x))
+	word := b.ConstWord(0, len(x))
+	for i := 0; i+n < len(x); i++ {
+		word[i] = x[i+n]
+	}
+	return word
+}
+
+// ShiftLeftBy returns x << amount for an amount computed by the circuit, as a barrel shifter
+func (b *CircuitBuilder) ShiftLeftBy(x, amount []Wire) []Wire {
+	for i, bit := range amount {
+		x = b.MuxWord(bit, x, b.ShiftLeft(x, shiftStep(i, len(x))))
+	}
+	return x
+}
+
+// ShiftRightBy returns x >> amount for an amount computed by the circuit, as a barrel shifter
+func (b *CircuitBuilder) ShiftRightBy(x, amount []Wire) []Wire {
+	for i, bit := range amount {
+		x = b.MuxWord(bit, x, b.ShiftRight(x, shiftStep(i, len(x))))
+	}
+	return x
+}
+
+// shiftStep returns 2^i, the shift of amount bit i, capped at the width so that it cannot overflow
+func shiftStep(i, width int) int {
+	if i >= 30 || 1<<uint(i) > width {
+		return width
+	}
+	return 1 << uint(i)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCircuitBuilderWords(t *testing.T) {
+	for n := 1; n <= 4; n++ {
+		b := NewCircuitBuilder()
+		x, y, s := b.InputWord("x", n), b.InputWord("y", n), b.InputWord("s", 3) // Shifts of 0 to 7, past the width
+		b.OutputWord("mul", b.Mul(x, y))
+		b.OutputWord("shl", b.ShiftLeftBy(x, s))
+		b.OutputWord("shr", b.ShiftRightBy(x, s))
+		circuit, inputs, outputs := b.Build()
+
+		mask := int64(1)<<uint(n) - 1
+		for xv := int64(0); xv <= mask; xv++ {
+			for yv := int64(0); yv <= mask; yv++ {
+				for sv := int64(0); sv < 8; sv++ {
+					words := map[string]*big.Int{"x": big.NewInt(xv), "y": big.NewInt(yv), "s": big.NewInt(sv)}
+					got, err := simulateCircuitWords(circuit, inputs, outputs, words)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want := xv * yv & mask; got["mul"].Int64() != want {
+						t.Errorf("%d bits: %d * %d = %v, want %d", n, xv, yv, got["mul"], want)
+					}
+					if want := xv << uint(sv) & mask; got["shl"].Int64() != want {
+						t.Errorf("%d bits: %d << %d = %v, want %d", n, xv, sv, got["shl"], want)
+					}
+					if want := xv >> uint(sv); got["shr"].Int64() != want {
+						t.Errorf("%d bits: %d >> %d = %v, want %d", n, xv, sv, got["shr"], want)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// ___________________________________________ Circuit Library _____________________________
+// Arithmetic circuits of any bit width, built with CircuitBuilder. libraryCircuit returns them in the form
+// garbleCircuit takes, with the operands on the input wires x_0, x_1, ... and y_0, y_1, ... (least significant
+// bit first, as wireValues encodes them) and the result on out_0, out_1, ... or on the single wire out.
+
+// Generators of the library circuits, declaring their own inputs and outputs
+var libraryCircuits = map[string]func(b *CircuitBuilder, n int){
+	"add": func(b *CircuitBuilder, n int) { // Ripple-carry, n+1 bit sum
+		x, y := libraryOperands(b, n)
+		sum, carry := b.AddCarry(x, y, b.Const(false))
+		b.OutputWord("out", append(sum, carry))
+	},
+	"add_cla": func(b *CircuitBuilder, n int) { // Carry-lookahead, n+1 bit sum
+		x, y := libraryOperands(b, n)
+		sum, carry := b.CarryLookaheadAdd(x, y)
+		b.OutputWord("out", append(sum, carry))
+	},
+	"sub": func(b *CircuitBuilder, n int) { // x - y modulo 2^n
+		x, y := libraryOperands(b, n)
+		b.OutputWord("out", b.Sub(x, y))
+	},
+	"eq": func(b *CircuitBuilder, n int) {
+		x, y := libraryOperands(b, n)
+		b.Output("out", b.Equal(x, y))
+	},
+	"lt": func(b *CircuitBuilder, n int) { // Unsigned x < y
+		x, y := libraryOperands(b, n)
+		b.Output("out", b.LessThan(x, y))
+	},
+	"lt_signed": func(b *CircuitBuilder, n int) { // Two's complement x < y
+		x, y := libraryOperands(b, n)
+		b.Output("out", b.SignedLessThan(x, y))
+	},
+	"mul": func(b *CircuitBuilder, n int) { // Schoolbook, 2n bit product
+		x, y := libraryOperands(b, n)
+		b.OutputWord("out", b.MulFull(x, y))
+	},
+	"mul_karatsuba": func(b *CircuitBuilder, n int) { // Karatsuba, 2n bit product
+		x, y := libraryOperands(b, n)
+		b.OutputWord("out", b.KaratsubaMul(x, y))
+	},
+	"div": func(b *CircuitBuilder, n int) { // Unsigned x / y, all ones for y == 0
+		x, y := libraryOperands(b, n)
+		q, _ := b.DivMod(x, y)
+		b.OutputWord("out", q)
+	},
+	"mod": func(b *CircuitBuilder, n int) { // Unsigned x % y, x for y == 0
+		x, y := libraryOperands(b, n)
+		_, r := b.DivMod(x, y)
+		b.OutputWord("out", r)
+	},
+	"min": func(b *CircuitBuilder, n int) { // Unsigned
+		x, y := libraryOperands(b, n)
+		b.OutputWord("out", b.Min(x, y))
+	},
+	"max": func(b *CircuitBuilder, n int) { // Unsigned
+		x, y := libraryOperands(b, n)
+		b.OutputWord("out", b.Max(x, y))
+	},
+	"popcount": func(b *CircuitBuilder, n int) { // Number of ones in x
+		b.OutputWord("out", b.Popcount(b.InputWord("x", n)))
+	},
+}
+
+func libraryOperands(b *CircuitBuilder, n int) ([]Wire, []Wire) {
+	return b.InputWord("x", n), b.InputWord("y", n)
+}
+
+// libraryCircuitNames returns the names libraryCircuit accepts
+func libraryCircuitNames() []string {
+	var names []string
+	for name := range libraryCircuits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// libraryCircuit returns the library circuit name over n bit operands
+func libraryCircuit(name string, n int) (map[string][]string, []string, []string, error) {
+	generate, found := libraryCircuits[name]
+	if !found {
+		return nil, nil, nil, fmt.Errorf("unknown library circuit %s", name)
+	}
+	if n < 1 {
+		return nil, nil, nil, fmt.Errorf("invalid bit width %d", n)
+	}
+	b := NewCircuitBuilder()
+	generate(b, n)
+	circuit, inputs, outputs := b.Build()
+	return circuit, inputs, outputs, nil
+}
+
+// ______ Building Blocks ______
+
+// extend zero-extends or truncates a word to the given width
+func (b *CircuitBuilder) extend(x []Wire, width int) []Wire {
+	if len(x) >= width {
+		return x[:width]
+	}
+	return append(append([]Wire(nil), x...), b.ConstWord(0, width-len(x))...)
+}
+
+// CarryLookaheadAdd returns x + y and the carry out. The carries come from a Kogge-Stone parallel prefix
+// over the (generate, propagate) pairs, with depth log2(n) instead of n. Generate and propagate are never
+// both set, so the OR in g | (p & g') is an XOR.
+func (b *CircuitBuilder) CarryLookaheadAdd(x, y []Wire) ([]Wire, Wire) {
+	sameWidth(x, y)
+	n := len(x)
+	g, p := b.AndWord(x, y), b.XorWord(x, y)
+	gs, ps := append([]Wire(nil), g...), append([]Wire(nil), p...) // Group generate/propagate of bits i-span+1 ... i
+	for span := 1; span < n; span *= 2 {
+		nextG, nextP := append([]Wire(nil), gs...), append([]Wire(nil), ps...)
+		for i := span; i < n; i++ {
+			nextG[i] = b.Xor(gs[i], b.And(ps[i], gs[i-span]))
+			if i >= 2*span { // Lower groups that reach bit 0 need no propagate
+				nextP[i] = b.And(ps[i], ps[i-span])
+			}
+		}
+		gs, ps = nextG, nextP
+	}
+	sum := make([]Wire, n)
+	sum[0] = p[0]
+	for i := 1; i < n; i++ {
+		sum[i] = b.Xor(p[i], gs[i-1])
+	}
+	return sum, gs[n-1]
+}
+
+// SignedLessThan returns whether x < y as two's complement integers: flipping the sign bits turns
+// the signed order into the unsigned one
+func (b *CircuitBuilder) SignedLessThan(x, y []Wire) Wire {
+	sameWidth(x, y)
+	n := len(x)
+	xs := append(append([]Wire(nil), x[:n-1]...), b.Not(x[n-1]))
+	ys := append(append([]Wire(nil), y[:n-1]...), b.Not(y[n-1]))
+	return b.LessThan(xs, ys)
+}
+
+// Min returns the smaller of the unsigned words
+func (b *CircuitBuilder) Min(x, y []Wire) []Wire {
+	return b.MuxWord(b.LessThan(x, y), y, x)
+}
+
+// Max returns the larger of the unsigned words
+func (b *CircuitBuilder) Max(x, y []Wire) []Wire {
+	return b.MuxWord(b.LessThan(x, y), x, y)
+}
+
+// MulFull returns the len(x)+len(y) bit product of the schoolbook method, adding the partial products
+// x & y_i into the running sum at bit i
+func (b *CircuitBuilder) MulFull(x, y []Wire) []Wire {
+	product := b.ConstWord(0, len(x)+len(y))
+	for i, yi := range y {
+		partial := make([]Wire, len(x))
+		for j := range partial {
+			partial[j] = b.And(x[j], yi)
+		}
+		if i == 0 {
+			copy(product, partial)
+			continue
+		}
+		// The sum so far has len(x)+i bits, so the carry goes into a bit that is still 0
+		sum, carry := b.AddCarry(product[i:i+len(x)], partial, b.Const(false))
+		copy(product[i:], sum)
+		product[i+len(x)] = carry
+	}
+	return product
+}
+
+// Operand width below which KaratsubaMul multiplies with the schoolbook method, which is smaller for short words
+const karatsubaThreshold = 16
+
+// KaratsubaMul returns the 2n bit product of two n bit words with Karatsuba's method: with x = x1*2^h + x0
+// and y = y1*2^h + y0, x*y = z2*2^2h + z1*2^h + z0 where z0 = x0*y0, z2 = x1*y1 and
+// z1 = (x0 + x1)(y0 + y1) - z0 - z2, three half size products instead of four.
+func (b *CircuitBuilder) KaratsubaMul(x, y []Wire) []Wire {
+	sameWidth(x, y)
+	n := len(x)
+	if n < karatsubaThreshold {
+		return b.MulFull(x, y)
+	}
+	h := n / 2
+	x0, x1, y0, y1 := x[:h], x[h:], y[:h], y[h:]
+
+	z0 := b.KaratsubaMul(x0, y0) // 2h bits
+	z2 := b.KaratsubaMul(x1, y1) // 2(n-h) bits
+	xs, xc := b.AddCarry(b.extend(x0, n-h), x1, b.Const(false))
+	ys, yc := b.AddCarry(b.extend(y0, n-h), y1, b.Const(false))
+	z1 := b.KaratsubaMul(append(xs, xc), append(ys, yc)) // 2(n-h+1) bits
+	z1 = b.Sub(z1, b.extend(z0, len(z1)))
+	z1 = b.Sub(z1, b.extend(z2, len(z1)))
+
+	product := append(append([]Wire(nil), z0...), z2...) // z0 + z2*2^2h, the halves do not overlap
+	high := b.Add(product[h:], b.extend(z1, 2*n-h))
+	return append(product[:h:h], high...)
+}
+
+// DivMod returns the quotient and remainder of unsigned restoring division. Division by zero gives
+// a quotient of all ones and the remainder x.
+func (b *CircuitBuilder) DivMod(x, y []Wire) ([]Wire, []Wire) {
+	sameWidth(x, y)
+	n := len(x)
+	divisor := b.extend(y, n+1)
+	q := make([]Wire, n)
+	r := b.ConstWord(0, n)
+	for i := n - 1; i >= 0; i-- {
+		shifted := append([]Wire{x[i]}, r...) // (r << 1) | x_i, one bit wider so that it cannot overflow
+		diff, borrow := b.SubBorrow(shifted, divisor)
+		q[i] = b.Not(borrow)
+		r = b.MuxWord(borrow, diff, shifted)[:n] // Restore when the divisor did not fit; the remainder is below y
+	}
+	return q, r
+}
+
+// Popcount returns the number of ones in x, as a word of bits.Len(len(x)) bits, summing halves recursively
+func (b *CircuitBuilder) Popcount(x []Wire) []Wire {
+	width := bits.Len(uint(len(x)))
+	if len(x) <= 1 {
+		return b.extend(x, width)
+	}
+	low, high := b.Popcount(x[:len(x)/2]), b.Popcount(x[len(x)/2:])
+	sum, carry := b.AddCarry(b.extend(low, len(high)), high, b.Const(false))
+	return b.extend(append(sum, carry), width)
+}
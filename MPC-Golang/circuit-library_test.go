@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// Results of the library circuits computed with Go arithmetic on n bit operands
+var libraryReferences = map[string]func(x, y uint64, n int) uint64{
+	"add":           func(x, y uint64, n int) uint64 { return x + y },
+	"add_cla":       func(x, y uint64, n int) uint64 { return x + y },
+	"sub":           func(x, y uint64, n int) uint64 { return (x - y) & (1<<uint(n) - 1) },
+	"eq":            func(x, y uint64, n int) uint64 { return libraryBool(x == y) },
+	"lt":            func(x, y uint64, n int) uint64 { return libraryBool(x < y) },
+	"lt_signed":     func(x, y uint64, n int) uint64 { return libraryBool(signExtend(x, n) < signExtend(y, n)) },
+	"mul":           func(x, y uint64, n int) uint64 { return x * y },
+	"mul_karatsuba": func(x, y uint64, n int) uint64 { return x * y },
+	"div": func(x, y uint64, n int) uint64 {
+		if y == 0 {
+			return 1<<uint(n) - 1
+		}
+		return x / y
+	},
+	"mod": func(x, y uint64, n int) uint64 {
+		if y == 0 {
+			return x
+		}
+		return x % y
+	},
+	"min": func(x, y uint64, n int) uint64 {
+		if x < y {
+			return x
+		}
+		return y
+	},
+	"max": func(x, y uint64, n int) uint64 {
+		if x > y {
+			return x
+		}
+		return y
+	},
+	"popcount": func(x, y uint64, n int) uint64 { return uint64(bits.OnesCount64(x)) },
+}
+
+func libraryBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Function that reads the n bit two's complement integer x
+func signExtend(x uint64, n int) int64 {
+	return int64(x<<uint(64-n)) >> uint(64-n)
+}
+
+// Function that returns the operands a library circuit is tested on: all of them up to 4 bits, otherwise
+// the edge cases (0, 1, the largest values on either side of the sign bit, all ones) and random operands
+func libraryOperandPairs(n int, random *rand.Rand) [][2]uint64 {
+	mask := uint64(1)<<uint(n) - 1
+	var pairs [][2]uint64
+	if n <= 4 {
+		for x := uint64(0); x <= mask; x++ {
+			for y := uint64(0); y <= mask; y++ {
+				pairs = append(pairs, [2]uint64{x, y})
+			}
+		}
+		return pairs
+	}
+	edges := []uint64{0, 1, 1<<uint(n-1) - 1, 1 << uint(n-1), mask}
+	for _, x := range edges {
+		for _, y := range edges {
+			pairs = append(pairs, [2]uint64{x, y})
+		}
+	}
+	for i := 0; i < 40; i++ {
+		pairs = append(pairs, [2]uint64{random.Uint64() & mask, random.Uint64() & mask})
+	}
+	return pairs
+}
+
+func TestLibraryCircuits(t *testing.T) {
+	for _, name := range libraryCircuitNames() {
+		reference, found := libraryReferences[name]
+		if !found {
+			t.Errorf("no reference for library circuit %s", name)
+			continue
+		}
+		widths := []int{1, 2, 3, 4, 8}
+		if name == "mul_karatsuba" {
+			widths = append(widths, karatsubaThreshold+1, 2*karatsubaThreshold-1) // Products still fit in 64 bits
+		}
+		random := rand.New(rand.NewSource(int64(len(name))))
+		for _, n := range widths {
+			circuit, inputs, outputs, err := libraryCircuit(name, n)
+			if err != nil {
+				t.Fatalf("%s %d: %v", name, n, err)
+			}
+			for _, pair := range libraryOperandPairs(n, random) {
+				x, y := pair[0], pair[1]
+				words := map[string]*big.Int{"x": new(big.Int).SetUint64(x), "y": new(big.Int).SetUint64(y)}
+				got, err := simulateCircuitWords(circuit, inputs, outputs, words)
+				if err != nil {
+					t.Fatalf("%s %d: %v", name, n, err)
+				}
+				if want := reference(x, y, n); got["out"].Cmp(new(big.Int).SetUint64(want)) != 0 {
+					t.Errorf("%s %d bits: x=%d y=%d gives %v, want %d", name, n, x, y, got["out"], want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ___________________________________________ Circuit Statistics _____________________________
+// What a circuit costs before running it: its gates, its depth and the bytes of garbled tables Merlin sends.
+
+// circuitStats describes the size and shape of a circuit
+type circuitStats struct {
+	gates         map[string]int // Gate type -> number of gates
+	inputs        map[string]int // Party prefix (x, y, ...) -> number of input wires
+	parties       []string       // Party prefixes in order of first appearance
+	unused        []string       // Inputs no gate reads, see unusedInputs
+	outputs       int
+	wires         int
+	andCount      int
+	andDepth      int
+	maxFanOut     int
+	maxFanOutWire string
+	scheme        string
+	tables        int // Gates garbled with a table
+	tableBytes    int // Estimated size of all garbled tables
+}
+
+// computeStats returns the statistics of a circuit garbled with k bit labels by the named scheme, where every
+// gate the scheme garbles that is not free under free-XOR takes the tableSize of the scheme
+func computeStats(circuit map[string][]string, inputs, outputs []string, k int, schemeName string) (circuitStats, error) {
+	scheme, err := garblingSchemeByName(schemeName)
+	if err != nil {
+		return circuitStats{}, err
+	}
+	if err := scheme.checkLabelLength(k); err != nil {
+		return circuitStats{}, err
+	}
+	stats := circuitStats{
+		scheme:   schemeName,
+		gates:    make(map[string]int),
+		inputs:   make(map[string]int),
+		outputs:  len(outputs),
+		wires:    len(circuit),
+		andCount: andCount(circuit),
+		andDepth: andDepth(circuit, inputs, outputs),
+		unused:   unusedInputs(circuit, inputs, outputs),
+	}
+	for _, input := range inputs {
+		if _, exists := circuit[input]; !exists {
+			stats.wires++ // Inputs nothing reads may be missing from the map
+		}
+		party, _, _ := splitBitName(input)
+		if _, exists := stats.inputs[party]; !exists {
+			stats.parties = append(stats.parties, party)
+		}
+		stats.inputs[party]++
+	}
+
+	fanOut := make(map[string]int)
+	for _, gate := range circuit {
+		if len(gate) == 0 {
+			continue
+		}
+		stats.gates[gate[0]]++
+		for _, in := range gate[1:] {
+			fanOut[in]++
+		}
+	}
+	for wire, count := range fanOut {
+		if count > stats.maxFanOut || count == stats.maxFanOut && wire < stats.maxFanOutWire {
+			stats.maxFanOut, stats.maxFanOutWire = count, wire
+		}
+	}
+
+	// The gates garbleCircuitScheme garbles: the cone of the outputs in the circuit as the scheme normalizes it
+	normalized := scheme.normalize(circuit, inputs, outputs)
+	for _, wire := range topoOrder(normalized, inputs, outputs) {
+		gate := normalized[wire]
+		if _, isInput := find(inputs, wire); isInput || len(gate) == 0 {
+			continue
+		}
+		if table, err := gateTruthTable(gate[0], len(gate)-1); err == nil {
+			if _, _, free := freeGateInputs(table); free {
+				continue
+			}
+		}
+		stats.tables++
+		stats.tableBytes += scheme.tableSize(len(gate)-1, k)
+	}
+	return stats, nil
+}
+
+// writeStats prints the statistics one measure per line, gate types sorted by name
+func writeStats(w io.Writer, stats circuitStats) error {
+	var lines []string
+	types := make([]string, 0, len(stats.gates))
+	total := 0
+	for gate, count := range stats.gates {
+		types = append(types, gate)
+		total += count
+	}
+	sort.Strings(types)
+	lines = append(lines, fmt.Sprintf("gates: %d", total))
+	for _, gate := range types {
+		lines = append(lines, fmt.Sprintf("  %s: %d", gate, stats.gates[gate]))
+	}
+	inputs := 0
+	for _, count := range stats.inputs {
+		inputs += count
+	}
+	lines = append(lines, fmt.Sprintf("inputs: %d", inputs))
+	for _, party := range stats.parties {
+		lines = append(lines, fmt.Sprintf("  %s: %d", party, stats.inputs[party]))
+	}
+	if len(stats.unused) > 0 {
+		lines = append(lines, fmt.Sprintf("  unused: %s", strings.Join(stats.unused, ", ")))
+	}
+	lines = append(lines,
+		fmt.Sprintf("outputs: %d", stats.outputs),
+		fmt.Sprintf("wires: %d", stats.wires),
+		fmt.Sprintf("AND gates: %d", stats.andCount),
+		fmt.Sprintf("AND depth: %d", stats.andDepth),
+	)
+	if stats.maxFanOut > 0 {
+		lines = append(lines, fmt.Sprintf("max fan-out: %d (%s)", stats.maxFanOut, stats.maxFanOutWire))
+	} else {
+		lines = append(lines, "max fan-out: 0")
+	}
+	lines = append(lines, fmt.Sprintf("garbled tables (%s): %d gates, %d bytes", stats.scheme, stats.tables, stats.tableBytes))
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestComputeStats(t *testing.T) {
+	circuit := map[string][]string{
+		"x_0": nil, "x_1": nil, "y_0": nil, "y_1": nil,
+		"t":   {"and", "x_0", "y_0"},
+		"u":   {"xor", "x_0", "x_1"},
+		"o_0": {"and", "t", "u"},
+		"o_1": {"or", "u", "y_0"},
+	}
+	inputs, outputs := []string{"x_0", "x_1", "y_0", "y_1"}, []string{"o_0", "o_1"}
+
+	for scheme, tableBytes := range map[string]int{"textbook": 3 * 4 * (16 + gcmTagSize + gcmNonceSize), "halfgates": 3 * 2 * 16} {
+		stats, err := computeStats(circuit, inputs, outputs, 128, scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.inputs["x"] != 2 || stats.inputs["y"] != 2 || len(stats.parties) != 2 || stats.parties[0] != "x" {
+			t.Errorf("%s: inputs %v of parties %v, want 2 of x and 2 of y", scheme, stats.inputs, stats.parties)
+		}
+		if stats.andCount != 3 || stats.andDepth != 2 {
+			t.Errorf("%s: %d AND gates of depth %d, want 3 of depth 2", scheme, stats.andCount, stats.andDepth)
+		}
+		if stats.maxFanOut != 2 || stats.maxFanOutWire != "u" { // Ties go to the first wire by name
+			t.Errorf("%s: max fan-out %d of %s, want 2 of u", scheme, stats.maxFanOut, stats.maxFanOutWire)
+		}
+		if stats.tables != 3 || stats.tableBytes != tableBytes {
+			t.Errorf("%s: %d tables of %d bytes, want 3 of %d", scheme, stats.tables, stats.tableBytes, tableBytes)
+		}
+
+		var buf bytes.Buffer
+		if err := writeStats(&buf, stats); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "  unused: y_1\n") {
+			t.Errorf("%s: unused input y_1 missing from\n%s", scheme, buf.String())
+		}
+	}
+}
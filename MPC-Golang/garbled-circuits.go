@@ -3,10 +3,10 @@ package main
 import (
 	"fmt"
 	"math/big"
-	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"golang.org/x/crypto/sha3" // For Keccak
 )
@@ -26,58 +26,140 @@ func product(n int) [][]int {
 	return result
 }
 
-// labelTruthTable labels the truth table for a given gate and its inputs.
-func labelTruthTable(outputName string, gate string, inputNames []string, labels map[string][]*big.Int, k int) ([][]*big.Int, error) {
-	var logicTable [][]int
-	switch gate {
-	case "and":
-		logicTable = [][]int{{0, 0}, {0, 1}}
-	case "or":
-		logicTable = [][]int{{0,1}, {1,1}}
-	case "nand":
-		logicTable = [][]int{{1, 1}, {1, 0}}
-	case "xnor":
-		logicTable = [][]int{{1, 0}, {0, 1}}
-	case "xor":
-		logicTable = [][]int{{0, 1}, {1,0}}
-	case "ornot":
-		logicTable = [][]int{{1, 0}, {1, 1}}
-	case "nor":
-		logicTable = [][]int{{1, 0}, {0, 0}}
-	case "andnot":
-		logicTable = [][]int{{0, 0}, {1, 0}}
-	case "not":
-		logicTable = [][]int{{1}, {0}}
-	case "const_0":
-		logicTable = [][]int{{0}}
-	case "const_1":
-		logicTable = [][]int{{1}}
-	default:
-		return nil, fmt.Errorf("unsupported gate %s", gate)
+// Truth tables of the named gates: the output for every input combination, reading the inputs as a
+// binary number with the first input as most significant bit ("and" is 0 for 00, 01, 10 and 1 for 11)
+var gateTruthTables = map[string]string{
+	"and":     "0001",
+	"or":      "0111",
+	"nand":    "1110",
+	"xnor":    "1001",
+	"xor":     "0110",
+	"ornot":   "1011", // a | ~b
+	"nor":     "1000",
+	"andnot":  "0010", // a & ~b
+	"not":     "10",
+	"buf":     "01",
+	"const_0": "0",
+	"const_1": "1",
+}
+
+// gateTruthTable returns the truth table of a gate with fanIn inputs. Besides the named gates of gateTruthTables,
+// lookup-table gates "lut_<truth table>" (e.g. "lut_01101001" for a 3-input XOR) carry their table in the name.
+func gateTruthTable(gate string, fanIn int) ([]int, error) {
+	table, found := gateTruthTables[gate]
+	if !found {
+		if !strings.HasPrefix(gate, "lut_") || strings.Trim(gate[4:], "01") != "" {
+			return nil, fmt.Errorf("unsupported gate %s", gate)
+		}
+		table = gate[4:]
+	}
+	if len(table) != 1<<fanIn {
+		return nil, fmt.Errorf("gate %s does not take %d inputs", gate, fanIn)
+	}
+	result := make([]int, len(table))
+	for i := range table {
+		result[i] = int(table[i] - '0')
+	}
+	return result, nil
+}
+
+// lutGate returns the gate computing a truth table, preferring the named gates over a "lut_" gate
+func lutGate(table []int) string {
+	bits := make([]byte, len(table))
+	for i, v := range table {
+		bits[i] = '0' + byte(v)
+	}
+	for gate, gateTable := range gateTruthTables {
+		if gateTable == string(bits) {
+			return gate
+		}
+	}
+	return "lut_" + string(bits)
+}
+
+// ______ Free-XOR ______
+// Every wire gets the labels label0 and label1 = label0 ⊕ Δ for one secret offset Δ, so the labels of an XOR
+// gate's output can be label0(a) ⊕ label0(b) and the evaluator computes them by XORing the labels it holds,
+// without a garbled table. The same goes for XNOR and NOT (Δ added to the output's label0) and every other gate
+// that is an XOR of inputs and a constant (see freeGateInputs).
+
+// newDelta returns the free-XOR offset Δ, a random k-bit value with its lowest bit set, so that the two labels
+// of a wire have different colours (see colorIndex)
+func newDelta(k int) *big.Int {
+	maxValue := new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(k)), nil) // 2^k as the upper bound for the label value
+	delta := randBigIntRange(big.NewInt(1), new(big.Int).Sub(maxValue, big.NewInt(1)))
+	return delta.SetBit(delta, 0, 1)
+}
+
+// colorIndex returns the row of a garbled table holding the output label for the given input labels: the lowest
+// bit of each label is its colour (point-and-permute), and the colours read as a binary number with the first
+// input as most significant bit give the row. The colour of a wire's 0 label is random, so the row tells the
+// evaluator nothing about the values.
+func colorIndex(labels []*big.Int) int {
+	row := 0
+	for _, label := range labels {
+		row = row*2 + int(label.Bit(0))
 	}
+	return row
+}
 
+// newLabels assigns a wire a random k-bit label for 0 and the label for 1 at offset delta, unless it has labels
+func newLabels(labels map[string][]*big.Int, wire string, delta *big.Int, k int) {
+	if _, exists := labels[wire]; exists {
+		return
+	}
 	maxValue := new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(k)), nil) // 2^k as the upper bound for the label value
 	minValue := big.NewInt(1)                                              // Minimum value for label
+	// maxValue - 1; randBigIntRange() is inclusive and we want [minValue, 2^k - 1]
+	label0 := randBigIntRange(minValue, new(big.Int).Sub(maxValue, big.NewInt(1)))
+	labels[wire] = []*big.Int{label0, new(big.Int).Xor(label0, delta)}
+}
+
+// freeGateInputs tells whether a truth table is the XOR of some of its inputs and a constant, which free-XOR
+// garbles without a table, and returns the positions of those inputs and the constant. Constant gates, which
+// read no input, are not free.
+func freeGateInputs(table []int) ([]int, int, bool) {
+	fanIn := tableFanIn(table)
+	var ins []int
+	for i := 0; i < fanIn; i++ {
+		if table[inputBit(fanIn, i)] != table[0] { // Flipping input i alone flips the output
+			ins = append(ins, i)
+		}
+	}
+	for row := range table {
+		value := table[0]
+		for _, i := range ins {
+			if row&inputBit(fanIn, i) != 0 {
+				value ^= 1
+			}
+		}
+		if table[row] != value {
+			return nil, 0, false
+		}
+	}
+	return ins, table[0], len(ins) > 0
+}
+
+// labelTruthTable labels the truth table for a given gate and its inputs. Wires without labels get labels
+// at the free-XOR offset delta.
+func labelTruthTable(outputName string, gate string, inputNames []string, labels map[string][]*big.Int, delta *big.Int, k int) ([][]*big.Int, error) {
+	logicTable, err := gateTruthTable(gate, len(inputNames))
+	if err != nil {
+		return nil, err
+	}
+
 	// labels for each variable
 	for _, varName := range append([]string{outputName}, inputNames...) {
-		if _, exists := labels[varName]; !exists {
-			// maxValue - 1; randBigIntRange() is inclusive and we want [minValue, 2^k - 1]
-			label0 := randBigIntRange(minValue, new(big.Int).Sub(maxValue, big.NewInt(1))) // Generate label for 0
-			label1 := randBigIntRange(minValue, new(big.Int).Sub(maxValue, big.NewInt(1))) // Generate label for 1
-			labels[varName] = []*big.Int{label0, label1}                                   // Assign the 0 and 1 labels for each var
-		}
+		newLabels(labels, varName, delta, k)
 	}
 
 	var labeledTable [][]*big.Int
 	for _, inpValues := range product(len(inputNames)) {
-		var outputValue int
-		if gate == "not" { // Special case for NOT gate
-			outputValue = logicTable[inpValues[0]][0]
-		} else if gate == "const_0" || gate == "const_1" { // Special case for constant gates
-			outputValue = logicTable[0][0]
-		} else {
-			outputValue = logicTable[inpValues[0]*2+inpValues[1]][0] 
+		row := 0 // The first input is the most significant bit of the row index
+		for _, v := range inpValues {
+			row = row*2 + v
 		}
+		outputValue := logicTable[row]
 		outputLabel := labels[outputName][outputValue]
 		inputLabels := make([]*big.Int, len(inputNames))
 		for i, inputName := range inputNames {
@@ -100,12 +182,11 @@ func combineKeys(keys [][]byte) []byte { // removed []uint64, k int from input
 	return h.Sum(nil) // Compute and return the hash
 }
 
-// Function that garbels the table
+// Function that garbels the table, putting every row at the colorIndex of its input labels
 func garbleTable(labeledTable [][]*big.Int, k int) ([][]byte, error) {
-	rand.Seed(time.Now().Unix())
 	result := make([][]byte, len(labeledTable))
 
-	for i, row := range labeledTable {
+	for _, row := range labeledTable {
 		outputLabel := row[0]
 		inputLabels := row[1:]
 
@@ -119,20 +200,16 @@ func garbleTable(labeledTable [][]*big.Int, k int) ([][]byte, error) {
 		// Combine input labels into a single key
 		key := combineKeys(inputLabelsBytes)
 
-		// Encrypt the output label with the combined key
-		ciphertext, nonce, err := symmetricEnc(key, outputLabel)
+		// Encrypt the output label with the combined key, at its full width so that it decrypts whatever its value
+		ciphertext, nonce, err := symmetricEnc(key, labelBytes(outputLabel, k))
 		if err != nil {
 			fmt.Println("Error encrypting label:", err)
 			return nil, err
 		}
 		// Combine ciphertext and nonce for storing in result
 		garbledEntry := append(ciphertext, nonce...)
-		result[i] = garbledEntry
+		result[colorIndex(inputLabels)] = garbledEntry
 	}
-	// Shuffle the result
-	rand.Shuffle(len(result), func(i, j int) {
-		result[i], result[j] = result[j], result[i]
-	})
 	return result, nil
 }
 
@@ -147,7 +224,7 @@ func topoOrder(circuit map[string][]string, inputs []string, outputs []string) [
 			return
 		}
 		visited[wireName] = true
-		if _, found := find(inputs, wireName); !found {
+		if _, found := find(inputs, wireName); !found && len(circuit[wireName]) > 0 { // Undriven wires have no gate
 			inputWireNames := circuit[wireName][1:] // Skipping the gate type
 			for _, inputWire := range inputWireNames {
 				visit(inputWire)
@@ -173,13 +250,28 @@ func find(slice []string, val string) (int, bool) {
 	return -1, false
 }
 
-// Function that garbles the circuit
-func garbleCircuit(circuit map[string][]string, inputs, outputs []string, k int) ([][]interface{}, map[string][]*big.Int, map[string]int) {
+// Function that garbles the circuit with the textbook scheme
+func garbleCircuit(circuit map[string][]string, inputs, outputs []string, k int) ([][]interface{}, map[string][]*big.Int, map[string]int, error) {
+	return garbleCircuitScheme(textbookScheme{}, circuit, inputs, outputs, k)
+}
+
+// Function that garbles the circuit, the gates that are not free with the given scheme. Every input gets
+// labels, including the inputs no output depends on, so both parties can still supply them.
+func garbleCircuitScheme(scheme garblingScheme, circuit map[string][]string, inputs, outputs []string, k int) ([][]interface{}, map[string][]*big.Int, map[string]int, error) {
+	if err := scheme.checkLabelLength(k); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ValidateCircuit(circuit, inputs, outputs); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid circuit:\n%v", err)
+	}
+	circuit = scheme.normalize(circuit, inputs, outputs)
+
 	labels := make(map[string][]*big.Int)
+	delta := newDelta(k)
 	var garbledTables [][]interface{}
 
-	// Topologically order all the wires
-	wires := topoOrder(circuit, inputs, outputs)
+	// Topologically order all the wires, starting from the inputs so that unused ones are kept
+	wires := topoOrder(circuit, inputs, append(append([]string(nil), inputs...), outputs...))
 
 	// Create a wire index map based on the topological order
 	wireIndex := make(map[string]int)
@@ -190,6 +282,7 @@ func garbleCircuit(circuit map[string][]string, inputs, outputs []string, k int)
 	for _, wireName := range wires {
 		if _, found := find(inputs, wireName); found {
 			fmt.Println("input wire:", wireName)
+			newLabels(labels, wireName, delta, k)
 			garbledTables = append(garbledTables, []interface{}{nil, nil}) // Input wire
 			continue
 		}
@@ -198,17 +291,6 @@ func garbleCircuit(circuit map[string][]string, inputs, outputs []string, k int)
 		inputWireNames := circuit[wireName][1:] // The input wires for this gate
 		fmt.Println(wireName, gate, inputWireNames)
 
-		labeledTable, err := labelTruthTable(wireName, gate, inputWireNames, labels, k)
-		if err != nil {
-			fmt.Println("Error labeling truth table:", err)
-			continue
-		}
-
-		garbledTable, err := garbleTable(labeledTable, k)
-		if err != nil {
-			panic("Error garbling table")
-		}
-
 		// Get input wire indexes
 		var inputWireIndexes []int
 		for _, inputWire := range inputWireNames {
@@ -218,53 +300,79 @@ func garbleCircuit(circuit map[string][]string, inputs, outputs []string, k int)
 		// Ensure all input wire indexes are valid
 		for _, i := range inputWireIndexes {
 			if i >= len(garbledTables) {
-				fmt.Println("Assertion failed: input wire index out of range")
-				return nil, nil, nil
+				return nil, nil, nil, fmt.Errorf("assertion failed: input wire index out of range")
+			}
+		}
+
+		logicTable, err := gateTruthTable(gate, len(inputWireNames))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("wire %s: %v", wireName, err)
+		}
+		if free, constant, isFree := freeGateInputs(logicTable); isFree {
+			// No table: the evaluator XORs the labels of the inputs listed
+			label0 := new(big.Int)
+			var freeIndexes []int
+			for _, i := range free {
+				label0.Xor(label0, labels[inputWireNames[i]][0])
+				freeIndexes = append(freeIndexes, inputWireIndexes[i])
+			}
+			if constant == 1 {
+				label0.Xor(label0, delta)
 			}
+			labels[wireName] = []*big.Int{label0, new(big.Int).Xor(label0, delta)}
+			garbledTables = append(garbledTables, []interface{}{nil, freeIndexes})
+			continue
+		}
+
+		garbledTable, err := scheme.garbleGate(wireName, gate, inputWireNames, labels, delta, len(garbledTables), k)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error garbling the table of %s: %v", wireName, err)
 		}
 
 		garbledTables = append(garbledTables, []interface{}{garbledTable, inputWireIndexes})
 	}
 
 	if len(garbledTables) != len(wires) {
-		fmt.Println("Assertion failed: garbled tables length does not match wires length")
+		return nil, nil, nil, fmt.Errorf("assertion failed: garbled tables length does not match wires length")
 	}
-	return garbledTables, labels, wireIndex
+	return garbledTables, labels, wireIndex, nil
 }
 
-// Function that evaluates the garbled circuit
+// Function that evaluates the garbled circuit of garbleCircuit
 func evalGarbledCircuit(garbledTables [][]interface{}, circuitInputLabels map[int]*big.Int, outputWireIndexes []int) ([]*big.Int, error) {
+	return evalGarbledCircuitScheme(textbookScheme{}, garbledTables, circuitInputLabels, outputWireIndexes, 0) // The textbook scheme needs no label length
+}
+
+// Function that evaluates the garbled circuit of garbleCircuitScheme with k bit labels
+func evalGarbledCircuitScheme(scheme garblingScheme, garbledTables [][]interface{}, circuitInputLabels map[int]*big.Int, outputWireIndexes []int, k int) ([]*big.Int, error) {
 	var evaluatedGates = make([]*big.Int, len(garbledTables))
 
 	for i, table := range garbledTables {
-		garbledTable, inputWireIndexes := table[0].([][]byte), table[1].([]int)
-
 		if label, exists := circuitInputLabels[i]; exists { // This is an input wire
 			evaluatedGates[i] = label
 			continue
 		}
+		garbledTable, _ := table[0].([][]byte) // Input wires and free gates have no table
+		inputWireIndexes, _ := table[1].([]int)
 
-		var outputLabel *big.Int
-		found := false
-
-		for _, row := range garbledTable {
-			ciphertext, nonce := row[:len(row)-12], row[len(row)-12:]
-
-			var gateInputLabels [][]byte
-			for _, index := range inputWireIndexes {
-				gateInputLabels = append(gateInputLabels, evaluatedGates[index].Bytes())
+		if garbledTable == nil {
+			if len(inputWireIndexes) == 0 {
+				return nil, fmt.Errorf("no label for input wire %d", i)
 			}
-
-			key := combineKeys(gateInputLabels)
-			label, err := symmetricDec(key, ciphertext, nonce)
-			if err == nil { // If decryption is successful, we found our label
-				outputLabel = label
-				found = true
-				break
+			outputLabel := new(big.Int) // Free-XOR gate
+			for _, index := range inputWireIndexes {
+				outputLabel.Xor(outputLabel, evaluatedGates[index])
 			}
+			evaluatedGates[i] = outputLabel
+			continue
 		}
 
-		if !found {
+		var inputLabels []*big.Int
+		for _, index := range inputWireIndexes {
+			inputLabels = append(inputLabels, evaluatedGates[index])
+		}
+		outputLabel, err := scheme.evalGate(garbledTable, inputLabels, i, k)
+		if err != nil {
 			return nil, fmt.Errorf("unable to decrypt garbled table for gate %d", i)
 		}
 		evaluatedGates[i] = outputLabel
@@ -293,9 +401,47 @@ func wireValues(prefix string, X *big.Int, bits int) map[string]*big.Int {
 	return values
 }
 
+// splitBitName splits a wire named like wireValues does ("x_3") into its prefix and bit index
+func splitBitName(wire string) (string, int, bool) {
+	i := strings.LastIndexByte(wire, '_')
+	if i <= 0 {
+		return wire, 0, false
+	}
+	index, err := strconv.Atoi(wire[i+1:])
+	if err != nil || index < 0 {
+		return wire, 0, false
+	}
+	return wire[:i], index, true
+}
+
+// busGroups groups wires into the buses wireValues encodes integers into, in order of first appearance.
+// The bits of each bus are sorted least significant first; wires without a bit index form a bus of their own.
+func busGroups(wires []string) ([]string, map[string][]string) {
+	var prefixes []string
+	groups := make(map[string][]string)
+	for _, wire := range wires {
+		prefix, _, _ := splitBitName(wire)
+		if _, exists := groups[prefix]; !exists {
+			prefixes = append(prefixes, prefix)
+		}
+		groups[prefix] = append(groups[prefix], wire)
+	}
+	for _, prefix := range prefixes {
+		sort.SliceStable(groups[prefix], func(i, j int) bool {
+			_, a, _ := splitBitName(groups[prefix][i])
+			_, b, _ := splitBitName(groups[prefix][j])
+			return a < b
+		})
+	}
+	return prefixes, groups
+}
+
 // MerlinSetupGarbledCircuit sets up the garbled circuit for Merlin's input wires and performs oblivious transfers for Arthur's inputs.
-func MerlinGarbledCircuit(circuit map[string][]string, inputWires, outputWires []string, X *big.Int, xBits, yBits, n, k int, ArthurChann, MerlinChann chan *big.Int, wg *sync.WaitGroup) {
-	garbledTables, labels, wireIndex := garbleCircuit(circuit, inputWires, outputWires, k)
+func MerlinGarbledCircuit(circuit map[string][]string, inputWires, outputWires []string, X *big.Int, xBits, yBits, n, k int, ArthurChann, MerlinChann chan *big.Int, wg *sync.WaitGroup) error {
+	garbledTables, labels, wireIndex, err := garbleCircuit(circuit, inputWires, outputWires, k)
+	if err != nil {
+		return err
+	}
 
 	var outputIndexes []int
 	for _, wire := range outputWires {
@@ -334,19 +480,24 @@ func MerlinGarbledCircuit(circuit map[string][]string, inputWires, outputWires [
 	// Wire inputs for Arthur ----------- this part needs revisiting
 	ArthurInputIndexes := make([]int, yBits)
 	for i := 0; i < yBits; i++ {
-		ArthurInputIndexes[i] = wireIndex[fmt.Sprintf("y_%d", i)]
+		wire := fmt.Sprintf("y_%d", i)
+		if len(labels[wire]) != 2 {
+			return fmt.Errorf("Arthur's input %s is not an input wire of the circuit", wire)
+		}
+		ArthurInputIndexes[i] = wireIndex[wire]
 	}
 	// Setup the oblivious transfer for Arthur's input wires
 	e, d, N := txtBookRSA(n)
 	wg.Add(yBits)
 	for i := 0; i < yBits; i++ {
-		m0, m1 := labels[fmt.Sprintf("y_%d", i)]
+		m0, m1 := labels[fmt.Sprintf("y_%d", i)][0], labels[fmt.Sprintf("y_%d", i)][1]
 		go ObliviousTransferMerlin(m0, m1, e, d, N, ArthurChann, MerlinChann, wg)
 	}
 	wg.Wait()
 
 	// In progress...
-
+	_ = garbledTables // Sent to Arthur once the protocol is done
+	return nil
 }
 
 func ArthurGarbledCircuit(Y *big.Int, yBits, n, k int, wg *sync.WaitGroup) {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// garbleAndEvaluate garbles a circuit with a scheme, evaluates it on the labels of the given input values and
+// decodes the output labels, failing the test on any error or on a label that is neither of an output's labels
+func garbleAndEvaluate(t *testing.T, scheme garblingScheme, circuit map[string][]string, inputs, outputs []string, values map[string]bool,
+	k int) map[string]bool {
+	t.Helper()
+	garbledTables, labels, wireIndex, err := garbleCircuitScheme(scheme, circuit, inputs, outputs, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputLabels := make(map[int]*big.Int)
+	for _, input := range inputs {
+		index, exists := wireIndex[input]
+		if !exists || len(labels[input]) != 2 {
+			t.Fatalf("input %s has no labels", input)
+		}
+		inputLabels[index] = labels[input][boolToInt(values[input])]
+	}
+	var outputIndexes []int
+	for _, output := range outputs {
+		outputIndexes = append(outputIndexes, wireIndex[output])
+	}
+	outputLabels, err := evalGarbledCircuitScheme(scheme, garbledTables, inputLabels, outputIndexes, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := make(map[string]bool)
+	for i, output := range outputs {
+		switch label := outputLabels[i]; {
+		case label.Cmp(labels[output][0]) == 0:
+			decoded[output] = false
+		case label.Cmp(labels[output][1]) == 0:
+			decoded[output] = true
+		default:
+			t.Fatalf("output %s: label %v is neither of its labels", output, label)
+		}
+	}
+	return decoded
+}
+
+func TestGarbleUnusedInputs(t *testing.T) {
+	// What optimizeCircuit leaves of o = a & (b | ~b)
+	circuit := map[string][]string{"x_0": nil, "y_0": nil, "o": {"buf", "x_0"}}
+	inputs, outputs := []string{"x_0", "y_0"}, []string{"o"}
+	if err := ValidateCircuit(circuit, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	if unused := unusedInputs(circuit, inputs, outputs); len(unused) != 1 || unused[0] != "y_0" {
+		t.Errorf("unused inputs %v, want [y_0]", unused)
+	}
+	for _, x := range []bool{false, true} {
+		got := garbleAndEvaluate(t, textbookScheme{}, circuit, inputs, outputs, map[string]bool{"x_0": x, "y_0": !x}, 128)
+		if got["o"] != x {
+			t.Errorf("o = %v for x_0 = %v", got["o"], x)
+		}
+	}
+
+	if _, _, _, err := garbleCircuit(map[string][]string{"o": {"and", "x_0"}}, nil, []string{"o"}, 128); err == nil {
+		t.Error("garbled a circuit with an and gate of one input")
+	}
+}
+
+func TestGarbleFreeXOR(t *testing.T) {
+	circuit := map[string][]string{
+		"x_0": nil, "y_0": nil,
+		"xo": {"xor", "x_0", "y_0"},
+		"xn": {"xnor", "x_0", "y_0"},
+		"n":  {"not", "x_0"},
+		"o":  {"and", "xo", "n"},
+	}
+	inputs, outputs := []string{"x_0", "y_0"}, []string{"xo", "xn", "n", "o"}
+	garbledTables, labels, wireIndex, err := garbleCircuit(circuit, inputs, outputs, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta := new(big.Int).Xor(labels["x_0"][0], labels["x_0"][1])
+	for wire, label := range labels {
+		if new(big.Int).Xor(label[0], label[1]).Cmp(delta) != 0 {
+			t.Errorf("labels of %s are not at the offset of x_0", wire)
+		}
+	}
+
+	for wire, offset := range map[string]*big.Int{"xo": new(big.Int), "xn": delta, "n": delta} {
+		if table := garbledTables[wireIndex[wire]]; table[0] != nil {
+			t.Errorf("%s has a garbled table", wire)
+		}
+		want := new(big.Int).Xor(labels["x_0"][0], offset)
+		if wire != "n" {
+			want.Xor(want, labels["y_0"][0])
+		}
+		if labels[wire][0].Cmp(want) != 0 {
+			t.Errorf("label0 of %s is not the XOR of its input labels", wire)
+		}
+	}
+	if table := garbledTables[wireIndex["o"]]; table[0] == nil {
+		t.Error("o has no garbled table")
+	}
+}
+
+func TestGarbleTableColorIndex(t *testing.T) {
+	labels := make(map[string][]*big.Int)
+	labeledTable, err := labelTruthTable("o", "and", []string{"a", "b"}, labels, newDelta(128), 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbledTable, err := garbleTable(labeledTable, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every row sits at the colour index of its input labels, and only that row decrypts under their key
+	for _, row := range labeledTable {
+		outputLabel, inputLabels := row[0], row[1:]
+		key := combineKeys([][]byte{inputLabels[0].Bytes(), inputLabels[1].Bytes()})
+		for i, entry := range garbledTable {
+			ciphertext, nonce := entry[:len(entry)-gcmNonceSize], entry[len(entry)-gcmNonceSize:]
+			label, err := symmetricDec(key, ciphertext, nonce)
+			if i == colorIndex(inputLabels) && (err != nil || label.Cmp(outputLabel) != 0) {
+				t.Errorf("row %d does not decrypt to the output label: %v", i, err)
+			}
+			if i != colorIndex(inputLabels) && err == nil {
+				t.Errorf("row %d decrypts under the key of row %d", i, colorIndex(inputLabels))
+			}
+		}
+	}
+}
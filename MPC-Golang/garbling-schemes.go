@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// ___________________________________________ Garbling Schemes _____________________________
+// Free-XOR gates never have a table (see freeGateInputs); a garbling scheme garbles and evaluates all other
+// gates. garbleCircuitScheme and evalGarbledCircuitScheme take the scheme, both parties must use the same one.
+
+// garblingScheme garbles the gates that are not free under free-XOR
+type garblingScheme interface {
+	// normalize rewrites a circuit into the gates the scheme garbles, keeping its inputs and outputs
+	normalize(circuit map[string][]string, inputs, outputs []string) map[string][]string
+	// garbleGate assigns the output wire its labels, at the free-XOR offset delta, and returns the garbled
+	// table of the gate. The gate index is unique within the circuit and known to the evaluator.
+	garbleGate(wire, gate string, inputNames []string, labels map[string][]*big.Int, delta *big.Int, gateIndex, k int) ([][]byte, error)
+	// evalGate returns the output label of a garbled gate for the input labels the evaluator holds
+	evalGate(garbledTable [][]byte, inputLabels []*big.Int, gateIndex, k int) (*big.Int, error)
+	// tableSize returns the bytes of the garbled table of a gate with fanIn inputs, at most, for k bit labels
+	tableSize(fanIn, k int) int
+	// checkLabelLength returns an error if the scheme cannot garble with k bit labels
+	checkLabelLength(k int) error
+}
+
+// Garbling schemes by the name the command line selects them with
+var garblingSchemes = map[string]garblingScheme{
+	"textbook":    textbookScheme{},
+	"grr3":        grr3Scheme{},
+	"halfgates":   halfGatesScheme{},
+	"threehalves": threeHalvesScheme{},
+}
+
+// garblingSchemeNames returns the names of garblingSchemes
+func garblingSchemeNames() []string {
+	var names []string
+	for name := range garblingSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Function that returns the garbling scheme called name
+func garblingSchemeByName(name string) (garblingScheme, error) {
+	scheme, found := garblingSchemes[name]
+	if !found {
+		return nil, fmt.Errorf("unknown garbling scheme %s (one of %v)", name, garblingSchemeNames())
+	}
+	return scheme, nil
+}
+
+// Function that checks a label length any scheme can garble with
+func positiveLabelLength(k int) error {
+	if k < 1 {
+		return fmt.Errorf("invalid label length %d", k)
+	}
+	return nil
+}
+
+// labelBytes returns a label as (k+7)/8 big-endian bytes
+func labelBytes(label *big.Int, k int) []byte {
+	return label.FillBytes(make([]byte, (k+7)/8))
+}
+
+// hashLabels hashes labels into a k-bit value with SHAKE256, tweaked by a gate index so that gates with the
+// same input labels get different values
+func hashLabels(gateIndex, k int, labels ...*big.Int) *big.Int {
+	h := sha3.NewShake256()
+	binary.Write(h, binary.BigEndian, uint64(gateIndex))
+	for _, label := range labels {
+		h.Write(labelBytes(label, k))
+	}
+	digest := make([]byte, (k+7)/8)
+	h.Read(digest)
+	hash := new(big.Int).SetBytes(digest)
+	return hash.Rsh(hash, uint(len(digest)*8-k)) // Keep k bits
+}
+
+// garbleConstant gives a constant gate random labels and returns the label of its value as its table, for the
+// schemes that only garble AND gates. The value is public, so its label may be.
+func garbleConstant(wire, gate string, labels map[string][]*big.Int, delta *big.Int, k int) [][]byte {
+	newLabels(labels, wire, delta, k)
+	return [][]byte{labelBytes(labels[wire][gate[6]-'0'], k)}
+}
+
+// ______ Textbook ______
+
+// Bytes garbleTable adds to each encrypted label: the AES-GCM tag and nonce of symmetricEnc
+const (
+	gcmTagSize   = 16
+	gcmNonceSize = 12
+)
+
+// textbookScheme encrypts the output label of every row of the truth table with AES-GCM under a key hashed from
+// the input labels (see garbleTable): 2^fanIn rows of ciphertext, tag and nonce
+type textbookScheme struct{}
+
+func (textbookScheme) normalize(circuit map[string][]string, inputs, outputs []string) map[string][]string {
+	return circuit
+}
+
+func (textbookScheme) garbleGate(wire, gate string, inputNames []string, labels map[string][]*big.Int, delta *big.Int, gateIndex, k int) ([][]byte, error) {
+	labeledTable, err := labelTruthTable(wire, gate, inputNames, labels, delta, k)
+	if err != nil {
+		return nil, err
+	}
+	return garbleTable(labeledTable, k)
+}
+
+func (textbookScheme) evalGate(garbledTable [][]byte, inputLabels []*big.Int, gateIndex, k int) (*big.Int, error) {
+	var gateInputLabels [][]byte
+	for _, label := range inputLabels {
+		gateInputLabels = append(gateInputLabels, label.Bytes())
+	}
+
+	// Point-and-permute: the colours of the input labels point to the one row they decrypt
+	row := garbledTable[colorIndex(inputLabels)]
+	ciphertext, nonce := row[:len(row)-gcmNonceSize], row[len(row)-gcmNonceSize:]
+	key := combineKeys(gateInputLabels)
+	return symmetricDec(key, ciphertext, nonce)
+}
+
+func (textbookScheme) tableSize(fanIn, k int) int {
+	return (1 << uint(fanIn)) * ((k+7)/8 + gcmTagSize + gcmNonceSize)
+}
+
+func (textbookScheme) checkLabelLength(k int) error {
+	if k < 64 {
+		return fmt.Errorf("the textbook scheme encrypts labels of at least 8 bytes, label length %d is too short", k)
+	}
+	return nil
+}
+
+// ______ Garbled Row Reduction ______
+
+// grr3Scheme is garbled row reduction (GRR3): rows are encrypted with a pad hashed from their input labels, and
+// the output label of row 0 (by colour) is chosen to be its pad, so that its ciphertext is zero and not sent.
+// A 2-input gate takes 3 rows of k bits.
+type grr3Scheme struct{}
+
+func (grr3Scheme) normalize(circuit map[string][]string, inputs, outputs []string) map[string][]string {
+	return circuit
+}
+
+func (grr3Scheme) garbleGate(wire, gate string, inputNames []string, labels map[string][]*big.Int, delta *big.Int, gateIndex, k int) ([][]byte, error) {
+	logicTable, err := gateTruthTable(gate, len(inputNames))
+	if err != nil {
+		return nil, err
+	}
+
+	// Row 0 holds the labels of colour 0, its output label is their hash
+	row, inputLabels := 0, make([]*big.Int, len(inputNames))
+	for i, name := range inputNames {
+		value := int(labels[name][0].Bit(0)) // The label for 1 has colour 0 when the label for 0 has colour 1
+		inputLabels[i] = labels[name][value]
+		row = row*2 + value
+	}
+	label := hashLabels(gateIndex, k, inputLabels...)
+	if logicTable[row] == 1 {
+		label.Xor(label, delta)
+	}
+	labels[wire] = []*big.Int{label, new(big.Int).Xor(label, delta)}
+
+	labeledTable, err := labelTruthTable(wire, gate, inputNames, labels, delta, k)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, len(labeledTable)-1)
+	for _, row := range labeledTable {
+		if index := colorIndex(row[1:]); index > 0 {
+			pad := hashLabels(gateIndex, k, row[1:]...)
+			result[index-1] = labelBytes(pad.Xor(pad, row[0]), k)
+		}
+	}
+	return result, nil
+}
+
+func (grr3Scheme) evalGate(garbledTable [][]byte, inputLabels []*big.Int, gateIndex, k int) (*big.Int, error) {
+	label := hashLabels(gateIndex, k, inputLabels...)
+	if index := colorIndex(inputLabels); index > 0 {
+		if index > len(garbledTable) {
+			return nil, fmt.Errorf("garbled table has %d rows, expected %d", len(garbledTable), 1<<uint(len(inputLabels))-1)
+		}
+		label.Xor(label, new(big.Int).SetBytes(garbledTable[index-1]))
+	}
+	return label, nil
+}
+
+func (grr3Scheme) tableSize(fanIn, k int) int {
+	return (1<<uint(fanIn) - 1) * ((k + 7) / 8)
+}
+
+func (grr3Scheme) checkLabelLength(k int) error {
+	return positiveLabelLength(k)
+}
+
+// ______ Half-Gates ______
+
+// halfGatesScheme is the half-gates scheme of Zahur, Rosulek and Evans over and/xor/not circuits (see andXorBasis):
+// an AND gate is the XOR of a garbler half gate, for an input the garbler knows (the colour of the other input's
+// label), and an evaluator half gate, for an input the evaluator knows (the colour of its own label), each a single
+// k-bit row.
+type halfGatesScheme struct{}
+
+func (halfGatesScheme) normalize(circuit map[string][]string, inputs, outputs []string) map[string][]string {
+	return andXorBasis(circuit, inputs, outputs)
+}
+
+func (halfGatesScheme) garbleGate(wire, gate string, inputNames []string, labels map[string][]*big.Int, delta *big.Int, gateIndex, k int) ([][]byte, error) {
+	if gate == "const_0" || gate == "const_1" {
+		return garbleConstant(wire, gate, labels, delta, k), nil
+	}
+	if gate != "and" || len(inputNames) != 2 {
+		return nil, fmt.Errorf("half-gates garbles and gates, not %s with %d inputs", gate, len(inputNames))
+	}
+	a, b := labels[inputNames[0]], labels[inputNames[1]]
+	pa, pb := a[0].Bit(0), b[0].Bit(0) // Colours of the 0 labels
+
+	// Garbler half gate: a & pb, the garbler knowing pb
+	ha0, ha1 := hashLabels(2*gateIndex, k, a[0]), hashLabels(2*gateIndex, k, a[1])
+	tg := new(big.Int).Xor(ha0, ha1)
+	if pb == 1 {
+		tg.Xor(tg, delta)
+	}
+	wg := new(big.Int).Set(ha0)
+	if pa == 1 {
+		wg.Xor(wg, tg)
+	}
+
+	// Evaluator half gate: a & (b ^ pb), the evaluator knowing b ^ pb, the colour of its label
+	hb0, hb1 := hashLabels(2*gateIndex+1, k, b[0]), hashLabels(2*gateIndex+1, k, b[1])
+	te := new(big.Int).Xor(hb0, hb1)
+	te.Xor(te, a[0])
+	we := new(big.Int).Set(hb0)
+	if pb == 1 {
+		we.Xor(we, new(big.Int).Xor(te, a[0]))
+	}
+
+	label0 := wg.Xor(wg, we)
+	labels[wire] = []*big.Int{label0, new(big.Int).Xor(label0, delta)}
+	return [][]byte{labelBytes(tg, k), labelBytes(te, k)}, nil
+}
+
+func (halfGatesScheme) evalGate(garbledTable [][]byte, inputLabels []*big.Int, gateIndex, k int) (*big.Int, error) {
+	if len(inputLabels) == 0 && len(garbledTable) == 1 { // Constant
+		return new(big.Int).SetBytes(garbledTable[0]), nil
+	}
+	if len(inputLabels) != 2 || len(garbledTable) != 2 {
+		return nil, fmt.Errorf("half-gates expects and gates with 2 rows, got %d inputs and %d rows", len(inputLabels), len(garbledTable))
+	}
+	a, b := inputLabels[0], inputLabels[1]
+	wg := hashLabels(2*gateIndex, k, a)
+	if a.Bit(0) == 1 {
+		wg.Xor(wg, new(big.Int).SetBytes(garbledTable[0]))
+	}
+	we := hashLabels(2*gateIndex+1, k, b)
+	if b.Bit(0) == 1 {
+		we.Xor(we, new(big.Int).SetBytes(garbledTable[1]))
+		we.Xor(we, a)
+	}
+	return wg.Xor(wg, we), nil
+}
+
+func (halfGatesScheme) tableSize(fanIn, k int) int {
+	if fanIn == 0 {
+		return (k + 7) / 8 // The label of a constant
+	}
+	return 2 * ((k + 7) / 8)
+}
+
+func (halfGatesScheme) checkLabelLength(k int) error {
+	return positiveLabelLength(k)
+}
+
+// ______ Three Halves ______
+
+// threeHalvesScheme is the three-halves scheme of Rosulek and Roy, over the circuits of halfGatesScheme: labels are
+// split into halves, the evaluator hashes A, B and A ⊕ B into half labels and combines them with the 3 half-label
+// ciphertexts G and with the halves of its input labels, through matrices that depend on the colours (i, j) of its
+// labels:
+//
+//	C = [H(A) ⊕ H(A ⊕ B), H(B) ⊕ H(A ⊕ B)] ⊕ V_ij G ⊕ R_ij [A_L, A_R, B_L, B_R]
+//
+// V_ij = [[i, 0, i ⊕ j], [0, j, i ⊕ j]] is public. R_ij is drawn by the garbler so that it is uniformly random
+// in every row whatever the values, and sent for every row encrypted under a hash of that row's labels, a byte
+// per row (the paper packs them into 5 bits per gate). An AND gate takes 1.5k bits and 4 bytes; k must be even.
+type threeHalvesScheme struct{}
+
+func (threeHalvesScheme) normalize(circuit map[string][]string, inputs, outputs []string) map[string][]string {
+	return andXorBasis(circuit, inputs, outputs)
+}
+
+// Matrices over GF(2), applied to vectors of half labels
+type bitMatrix [][]uint
+
+// Function that returns the product of two bit matrices
+func (m bitMatrix) mul(n bitMatrix) bitMatrix {
+	product := make(bitMatrix, len(m))
+	for r := range m {
+		product[r] = make([]uint, len(n[0]))
+		for c := range product[r] {
+			for i := range n {
+				product[r][c] ^= m[r][i] & n[i][c]
+			}
+		}
+	}
+	return product
+}
+
+// Function that returns the sum of m and s times n
+func (m bitMatrix) add(s uint, n bitMatrix) bitMatrix {
+	sum := make(bitMatrix, len(m))
+	for r := range m {
+		sum[r] = make([]uint, len(m[r]))
+		for c := range sum[r] {
+			sum[r][c] = m[r][c] ^ s&n[r][c]
+		}
+	}
+	return sum
+}
+
+// Function that returns the XOR of the halves in v the rows of m select
+func (m bitMatrix) apply(v []*big.Int) []*big.Int {
+	result := make([]*big.Int, len(m))
+	for r := range m {
+		result[r] = new(big.Int)
+		for c, bit := range m[r] {
+			if bit == 1 {
+				result[r].Xor(result[r], v[c])
+			}
+		}
+	}
+	return result
+}
+
+// threeHalvesV returns the public matrix V_ij of the colours i, j
+func threeHalvesV(i, j uint) bitMatrix {
+	return bitMatrix{{i, 0, i ^ j}, {0, j, i ^ j}}
+}
+
+// Function that returns the 2x2 matrix s I
+func scalarMatrix(s uint) bitMatrix {
+	return bitMatrix{{s, 0}, {0, s}}
+}
+
+// Function that splits a k-bit label into its high and low halves
+func splitLabel(label *big.Int, k int) []*big.Int {
+	low := new(big.Int).SetBit(new(big.Int), k/2, 1)
+	low.Sub(low, big.NewInt(1))
+	return []*big.Int{new(big.Int).Rsh(label, uint(k/2)), low.And(low, label)}
+}
+
+// Function that joins the halves of splitLabel
+func joinLabel(halves []*big.Int, k int) *big.Int {
+	label := new(big.Int).Lsh(halves[0], uint(k/2))
+	return label.Or(label, halves[1])
+}
+
+// Function that hashes labels into a half label, tweaked by the gate index and what is hashed (A, B, A ⊕ B)
+func hashHalf(gateIndex, which, k int, labels ...*big.Int) *big.Int {
+	hash := hashLabels(4*gateIndex+which, k, labels...)
+	return hash.Rsh(hash, uint(k-k/2))
+}
+
+// Function that returns the pad encrypting the control byte (R_ij) of the row of labels a, b
+func controlPad(gateIndex, k int, a, b *big.Int) byte {
+	return byte(hashLabels(4*gateIndex+3, k, a, b).Uint64())
+}
+
+// Function that returns the matrices R^A_ij, R^B_ij packed into a byte, row by row
+func packControl(ra, rb bitMatrix) byte {
+	var packed byte
+	for r := 0; r < 2; r++ {
+		for _, bit := range append(append([]uint(nil), ra[r]...), rb[r]...) {
+			packed = packed<<1 | byte(bit)
+		}
+	}
+	return packed
+}
+
+// Function that returns the matrices of packControl
+func unpackControl(packed byte) (bitMatrix, bitMatrix) {
+	ra, rb := bitMatrix{{0, 0}, {0, 0}}, bitMatrix{{0, 0}, {0, 0}}
+	for r := 0; r < 2; r++ {
+		for c := 0; c < 4; c++ {
+			bit := uint(packed>>uint(7-4*r-c)) & 1
+			if c < 2 {
+				ra[r][c] = bit
+			} else {
+				rb[r][c-2] = bit
+			}
+		}
+	}
+	return ra, rb
+}
+
+func (threeHalvesScheme) garbleGate(wire, gate string, inputNames []string, labels map[string][]*big.Int, delta *big.Int, gateIndex, k int) ([][]byte, error) {
+	if gate == "const_0" || gate == "const_1" {
+		return garbleConstant(wire, gate, labels, delta, k), nil
+	}
+	if gate != "and" || len(inputNames) != 2 {
+		return nil, fmt.Errorf("three-halves garbles and gates, not %s with %d inputs", gate, len(inputNames))
+	}
+	a, b := labels[inputNames[0]], labels[inputNames[1]]
+	alpha, beta := a[0].Bit(0), b[0].Bit(0) // Colours of the 0 labels, so value = colour ⊕ alpha
+	x0 := new(big.Int).Xor(a[0], b[0])
+	x := []*big.Int{x0, new(big.Int).Xor(x0, delta)} // Labels of A ⊕ B, for a ⊕ b = 0 and 1
+
+	// Hashes of the labels of colour 0 and 1, and their differences, which mask G
+	var hA, hB, hX [2]*big.Int
+	for c := uint(0); c < 2; c++ {
+		hA[c] = hashHalf(gateIndex, 0, k, a[c^alpha])
+		hB[c] = hashHalf(gateIndex, 1, k, b[c^beta])
+		hX[c] = hashHalf(gateIndex, 2, k, x[c^alpha^beta])
+	}
+
+	// Random Q_A, Q' and Q_B with V_01 Q_A ⊕ V_10 Q_B = I, which makes the gate correct in every row: 6 random
+	// bits for each of Q_A and Q', and 2 for Q_B, whose first and last rows the equation fixes
+	random := randBigIntRange(big.NewInt(0), big.NewInt(1<<14-1)).Uint64()
+	bits := func(n int) bitMatrix {
+		m := make(bitMatrix, n)
+		for r := range m {
+			m[r] = []uint{uint(random & 1), uint(random >> 1 & 1)}
+			random >>= 2
+		}
+		return m
+	}
+	qa, qp := bits(3), bits(3)
+	t := scalarMatrix(1).add(1, threeHalvesV(0, 1).mul(qa)) // V_10 Q_B = [Q_B0 ⊕ Q_B2, Q_B2] must be t
+	qb := bitMatrix{{t[0][0] ^ t[1][0], t[0][1] ^ t[1][1]}, bits(1)[0], t[1]}
+
+	// With a = i ⊕ alpha and b = j ⊕ beta, the output label is C_0 ⊕ ab Δ in every row (i, j) for
+	// G = masks ⊕ Q_Δ Δ ⊕ Q_A A_0 ⊕ Q_B B_0, C_0 = [hashes of colour 0] ⊕ K_Δ Δ ⊕ K_A A_0 ⊕ K_B B_0
+	// and R_ij = [K_A ⊕ V_ij Q_A, K_B ⊕ V_ij Q_B]
+	ka := scalarMatrix(beta).add(1, threeHalvesV(1, 0).mul(qp.add(1, qa)))
+	kb := scalarMatrix(alpha).add(1, threeHalvesV(0, 1).mul(qp.add(1, qb)))
+	kd := scalarMatrix(alpha&beta).add(alpha, ka).add(beta, kb)
+	qd := qp.add(alpha, qa).add(beta, qb)
+
+	ah, bh, dh := splitLabel(a[0], k), splitLabel(b[0], k), splitLabel(delta, k)
+	g := []*big.Int{new(big.Int).Xor(hA[0], hA[1]), new(big.Int).Xor(hB[0], hB[1]), new(big.Int).Xor(hX[0], hX[1])}
+	c0 := []*big.Int{new(big.Int).Xor(hA[0], hX[0]), new(big.Int).Xor(hB[0], hX[0])}
+	for _, term := range [][]*big.Int{qd.apply(dh), qa.apply(ah), qb.apply(bh)} {
+		for r := range g {
+			g[r].Xor(g[r], term[r])
+		}
+	}
+	for _, term := range [][]*big.Int{kd.apply(dh), ka.apply(ah), kb.apply(bh)} {
+		for r := range c0 {
+			c0[r].Xor(c0[r], term[r])
+		}
+	}
+	label0 := joinLabel(c0, k)
+	labels[wire] = []*big.Int{label0, new(big.Int).Xor(label0, delta)}
+
+	// Control bytes of the rows by colour, each under a pad only that row's labels give
+	control := make([]byte, 4)
+	for i := uint(0); i < 2; i++ {
+		for j := uint(0); j < 2; j++ {
+			v := threeHalvesV(i, j)
+			packed := packControl(ka.add(1, v.mul(qa)), kb.add(1, v.mul(qb)))
+			control[2*i+j] = packed ^ controlPad(gateIndex, k, a[i^alpha], b[j^beta])
+		}
+	}
+	return [][]byte{labelBytes(g[0], k/2), labelBytes(g[1], k/2), labelBytes(g[2], k/2), control}, nil
+}
+
+func (threeHalvesScheme) evalGate(garbledTable [][]byte, inputLabels []*big.Int, gateIndex, k int) (*big.Int, error) {
+	if len(inputLabels) == 0 && len(garbledTable) == 1 { // Constant
+		return new(big.Int).SetBytes(garbledTable[0]), nil
+	}
+	if len(inputLabels) != 2 || len(garbledTable) != 4 || len(garbledTable[3]) != 4 {
+		return nil, fmt.Errorf("three-halves expects and gates with 3 half labels and 4 control bytes, got %d inputs and %d rows", len(inputLabels), len(garbledTable))
+	}
+	a, b := inputLabels[0], inputLabels[1]
+	i, j := a.Bit(0), b.Bit(0)
+	hx := hashHalf(gateIndex, 2, k, new(big.Int).Xor(a, b))
+	c := []*big.Int{hashHalf(gateIndex, 0, k, a), hashHalf(gateIndex, 1, k, b)}
+	c[0].Xor(c[0], hx)
+	c[1].Xor(c[1], hx)
+
+	g := make([]*big.Int, 3)
+	for r := range g {
+		g[r] = new(big.Int).SetBytes(garbledTable[r])
+	}
+	ra, rb := unpackControl(garbledTable[3][2*i+j] ^ controlPad(gateIndex, k, a, b))
+	for _, term := range [][]*big.Int{threeHalvesV(i, j).apply(g), ra.apply(splitLabel(a, k)), rb.apply(splitLabel(b, k))} {
+		c[0].Xor(c[0], term[0])
+		c[1].Xor(c[1], term[1])
+	}
+	return joinLabel(c, k), nil
+}
+
+func (threeHalvesScheme) tableSize(fanIn, k int) int {
+	if fanIn == 0 {
+		return (k + 7) / 8 // The label of a constant
+	}
+	return 3*((k/2+7)/8) + 4
+}
+
+func (threeHalvesScheme) checkLabelLength(k int) error {
+	if k%2 != 0 {
+		return fmt.Errorf("three-halves splits labels into halves, label length %d is odd", k)
+	}
+	return positiveLabelLength(k)
+}
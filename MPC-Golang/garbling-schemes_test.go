@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestGarblingSchemesRoundTrip(t *testing.T) {
+	b := NewCircuitBuilder()
+	x := b.InputWord("x", 3)
+	b.OutputWord("out", []Wire{
+		b.Gate("lut_01101000", x[0], x[1], x[2]),
+		b.Gate("xnor", x[0], x[2]),
+		b.Gate("ornot", x[1], x[2]),
+		b.Not(x[1]),
+		b.Const(false),
+	})
+	gates, gatesInputs, gatesOutputs := b.Build()
+	outV, outVInputs, outVOutputs, err := parseVerilog("out.v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	circuits := map[string]struct {
+		circuit         map[string][]string
+		inputs, outputs []string
+	}{
+		"gates": {gates, gatesInputs, gatesOutputs},
+		"out.v": {outV, outVInputs, outVOutputs},
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for _, schemeName := range garblingSchemeNames() {
+		scheme, err := garblingSchemeByName(schemeName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, c := range circuits {
+			for round := 0; round < 4; round++ {
+				values := make(map[string]bool)
+				for _, input := range c.inputs {
+					values[input] = random.Intn(2) == 1
+				}
+				want, err := simulateCircuit(c.circuit, c.inputs, c.outputs, values)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := garbleAndEvaluate(t, scheme, c.circuit, c.inputs, c.outputs, values, 128)
+				for _, output := range c.outputs {
+					if got[output] != want[output] {
+						t.Fatalf("%s, %s: output %s decodes to %v for %v, want %v", schemeName, name, output, got[output], values, want[output])
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := garblingSchemeByName("yao"); err == nil {
+		t.Error("no error for an unknown scheme")
+	}
+}
+
+func TestThreeHalves(t *testing.T) {
+	// Every draw of Q_A, Q' and Q_B must give a correct gate
+	circuit := map[string][]string{"x_0": nil, "y_0": nil, "out": {"and", "x_0", "y_0"}}
+	inputs, outputs := []string{"x_0", "y_0"}, []string{"out"}
+	for round := 0; round < 64; round++ {
+		x, y := round&1 == 1, round&2 == 2
+		if got := garbleAndEvaluate(t, threeHalvesScheme{}, circuit, inputs, outputs, map[string]bool{"x_0": x, "y_0": y}, 64); got["out"] != (x && y) {
+			t.Fatalf("round %d: %v & %v decodes to %v", round, x, y, got["out"])
+		}
+	}
+
+	if _, _, _, err := garbleCircuitScheme(threeHalvesScheme{}, circuit, inputs, outputs, 127); err == nil {
+		t.Error("garbled with an odd label length")
+	}
+	if _, err := computeStats(circuit, inputs, outputs, 127, "threehalves"); err == nil {
+		t.Error("statistics for an odd label length")
+	}
+}
+
+func TestTextbookShortLabels(t *testing.T) {
+	// Labels with leading zero bytes, which a label of its minimal byte length would lose
+	delta := newDelta(64)
+	labels := map[string][]*big.Int{}
+	for i, wire := range []string{"a", "b", "o"} {
+		label0 := big.NewInt(int64(i + 1))
+		labels[wire] = []*big.Int{label0, new(big.Int).Xor(label0, delta)}
+	}
+	garbledTable, err := textbookScheme{}.garbleGate("o", "and", []string{"a", "b"}, labels, delta, 0, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := 0; row < 4; row++ {
+		a, b := row>>1, row&1
+		label, err := textbookScheme{}.evalGate(garbledTable, []*big.Int{labels["a"][a], labels["b"][b]}, 0, 64)
+		if err != nil {
+			t.Fatalf("%d & %d: %v", a, b, err)
+		}
+		if label.Cmp(labels["o"][a&b]) != 0 {
+			t.Errorf("%d & %d decrypts to %v, want %v", a, b, label, labels["o"][a&b])
+		}
+	}
+
+	circuit := map[string][]string{"x_0": nil, "y_0": nil, "out": {"and", "x_0", "y_0"}}
+	inputs, outputs := []string{"x_0", "y_0"}, []string{"out"}
+	for round := 0; round < 64; round++ {
+		x, y := round&1 == 1, round&2 == 2
+		if got := garbleAndEvaluate(t, textbookScheme{}, circuit, inputs, outputs, map[string]bool{"x_0": x, "y_0": y}, 64); got["out"] != (x && y) {
+			t.Fatalf("round %d: %v & %v decodes to %v", round, x, y, got["out"])
+		}
+	}
+	if _, _, _, err := garbleCircuitScheme(textbookScheme{}, circuit, inputs, outputs, 32); err == nil {
+		t.Error("garbled with labels shorter than 8 bytes")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ___________________________________________ Command Line _____________________________
+// Tools for looking at circuits before garbling them:
+//
+//	circuits stats [-k bits] [-scheme textbook|grr3|halfgates|threehalves] <circuit>
+//	circuits dot [-set x=9001 -set y=1337 ...] <circuit>
+//	circuits export <circuit> <file.v|file.txt|file.bristol>
+//	circuits optimize [-ands] [-rebalance] [-o file] <circuit>
+//
+// A circuit is a .v, .blif, .json (Yosys) or .txt/.bristol (Bristol Fashion) file, or lib:<name>:<bits>
+// for a library circuit (see libraryCircuitNames).
+
+const usage = `usage:
+  circuits stats [-k bits] [-scheme <garbling scheme>] <circuit>
+  circuits dot [-set <input bus>=<integer> ...] <circuit>
+  circuits export <circuit> <output .v, .txt or .bristol file>
+  circuits optimize [-ands] [-rebalance] [-o <output file>] <circuit>
+
+circuits are .v, .blif, .json (Yosys), .txt or .bristol (Bristol Fashion) files, or lib:<name>:<bits>`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "stats":
+		err = statsCommand(args)
+	case "dot":
+		err = dotCommand(args)
+	case "export":
+		err = exportCommand(args)
+	case "optimize":
+		err = optimizeCommand(args)
+	default:
+		err = fmt.Errorf("unknown command %s\n%s", command, usage)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Function that reads a circuit file by its extension, or builds a library circuit
+func loadCircuit(name string) (map[string][]string, []string, []string, error) {
+	if spec, isLibrary := strings.CutPrefix(name, "lib:"); isLibrary {
+		circuitName, bits, found := strings.Cut(spec, ":")
+		if !found {
+			return nil, nil, nil, fmt.Errorf("expected lib:<name>:<bits>, got %s", name)
+		}
+		n, err := strconv.Atoi(bits)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid bit width %s", bits)
+		}
+		return libraryCircuit(circuitName, n)
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".v":
+		return parseVerilog(name)
+	case ".blif":
+		return parseBLIF(name)
+	case ".json":
+		return parseYosysJSON(name)
+	case ".txt", ".bristol":
+		return parseBristol(name)
+	}
+	return nil, nil, nil, fmt.Errorf("unknown circuit format %s", name)
+}
+
+func statsCommand(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	k := flags.Int("k", 128, "label length in bits")
+	scheme := flags.String("scheme", "textbook", fmt.Sprintf("garbling scheme, one of %v", garblingSchemeNames()))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	circuit, inputs, outputs, err := loadCircuit(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	stats, err := computeStats(circuit, inputs, outputs, *k, *scheme)
+	if err != nil {
+		return err
+	}
+	return writeStats(os.Stdout, stats)
+}
+
+// wordFlags collects -set prefix=integer flags, the values of input buses
+type wordFlags map[string]*big.Int
+
+func (f wordFlags) String() string {
+	return fmt.Sprint(map[string]*big.Int(f))
+}
+
+func (f wordFlags) Set(s string) error {
+	prefix, value, found := strings.Cut(s, "=")
+	if !found {
+		return fmt.Errorf("expected <input bus>=<integer>, got %s", s)
+	}
+	word, ok := new(big.Int).SetString(value, 0)
+	if !ok {
+		return fmt.Errorf("invalid integer %s", value)
+	}
+	f[prefix] = word
+	return nil
+}
+
+func dotCommand(args []string) error {
+	flags := flag.NewFlagSet("dot", flag.ContinueOnError)
+	words := make(wordFlags)
+	flags.Var(words, "set", "value of an input bus, shown on every wire with a simulation (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	circuit, inputs, outputs, err := loadCircuit(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var values map[string]bool
+	if len(words) > 0 {
+		if values, err = encodeWords(inputs, words); err != nil {
+			return err
+		}
+		if values, err = simulateCircuit(circuit, inputs, outputs, values); err != nil {
+			return err
+		}
+	}
+	return writeDot(os.Stdout, circuit, inputs, outputs, values)
+}
+
+// exportCommand writes a circuit as structural Verilog or Bristol Fashion, by the extension of the output file
+func exportCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%s", usage)
+	}
+	circuit, inputs, outputs, err := loadCircuit(args[0])
+	if err != nil {
+		return err
+	}
+	return writeCircuitFile(args[1], circuit, inputs, outputs)
+}
+
+// Function that writes a circuit file by its extension, the Verilog module named after the file
+func writeCircuitFile(name string, circuit map[string][]string, inputs, outputs []string) error {
+	var write func(w io.Writer) error
+	switch extension := strings.ToLower(filepath.Ext(name)); extension {
+	case ".v":
+		module := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		write = func(w io.Writer) error { return writeVerilog(w, module, circuit, inputs, outputs) }
+	case ".txt", ".bristol":
+		write = func(w io.Writer) error { return writeBristol(w, circuit, inputs, outputs) }
+	default:
+		return fmt.Errorf("unknown output format %s", name)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// optimizeCommand runs the optimization passes over a circuit, printing the measure each one improves,
+// and writes the result if asked to
+func optimizeCommand(args []string) error {
+	flags := flag.NewFlagSet("optimize", flag.ContinueOnError)
+	ands := flags.Bool("ands", false, "also rewrite into AND/XOR gates with as few ANDs as possible, for free-XOR")
+	rebalance := flags.Bool("rebalance", false, "also rebuild chains of and, or and xor gates as trees of least AND depth")
+	output := flags.String("o", "", "write the optimized circuit to this .v, .txt or .bristol file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+	circuit, inputs, outputs, err := loadCircuit(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	circuit, report := optimizeCircuit(circuit, inputs, outputs)
+	fmt.Println(report)
+	if *ands {
+		circuit, report = minimizeANDs(circuit, inputs, outputs)
+		fmt.Println(report)
+	}
+	if *rebalance {
+		circuit, report = rebalanceCircuit(circuit, inputs, outputs)
+		fmt.Println(report)
+	}
+	if *output == "" {
+		return nil
+	}
+	return writeCircuitFile(*output, circuit, inputs, outputs)
+}
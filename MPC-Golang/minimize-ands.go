@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// ___________________________________________ AND Count Minimization _____________________________
+// With free-XOR garbling, XOR, XNOR and NOT gates (a NOT is an XOR with the constant 1) cost no ciphertexts,
+// so the size of a garbled circuit is set by its other gates.
+
+// Function that tells whether a truth table is affine: an XOR of inputs and constants, free under free-XOR
+func isAffineTable(table []int) bool {
+	coefficients := append([]int(nil), table...) // Algebraic normal form, as in anfGates
+	for bit := 1; bit < len(coefficients); bit <<= 1 {
+		for m := range coefficients {
+			if m&bit != 0 {
+				coefficients[m] ^= coefficients[m^bit]
+			}
+		}
+	}
+	for m, c := range coefficients {
+		if c == 1 && bits.OnesCount(uint(m)) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// andCount returns the number of gates that are not free under free-XOR, the AND gates of an AND/XOR circuit
+func andCount(circuit map[string][]string) int {
+	count := 0
+	for _, gate := range circuit {
+		if len(gate) == 0 {
+			continue
+		}
+		if table, err := gateTruthTable(gate[0], len(gate)-1); err != nil || !isAffineTable(table) {
+			count++
+		}
+	}
+	return count
+}
+
+// minimizeANDs rewrites a circuit into and, xor, not, buf and constant gates with as few ANDs as it can:
+// OR-like gates become a ^ b ^ (a & b), lookup tables of up to three inputs get their minimum number of ANDs
+// (see anfGates), XORs of two ANDs sharing an input are factored ((a & b) ^ (a & c) == a & (b ^ c)), and
+// optimizeCircuit removes constants, duplicates and dead gates in between.
+func minimizeANDs(circuit map[string][]string, inputs, outputs []string) (map[string][]string, optimizeReport) {
+	before := andCount(circuit)
+	optimized, _ := optimizeCircuit(circuit, inputs, outputs)
+	basis := andXorBasis(optimized, inputs, outputs)
+	for factorAnds(basis, outputs) {
+	}
+	// The optimizer absorbs NOT gates into named gates such as andnot, which andXorBasis turns back into one AND
+	optimized, _ = optimizeCircuit(basis, inputs, outputs)
+	basis = andXorBasis(optimized, inputs, outputs)
+	return basis, optimizeReport{"AND gates", before, andCount(basis)}
+}
+
+// factorAnds rewrites every XOR of two AND gates that share an input and are read by nothing else,
+// (a & b) ^ (a & c), into a & (b ^ c), saving an AND. It reports whether it rewrote anything.
+func factorAnds(circuit map[string][]string, outputs []string) bool {
+	readers := make(map[string]int)
+	for _, gate := range circuit {
+		for _, in := range gate[min(1, len(gate)):] {
+			readers[in]++
+		}
+	}
+	for _, output := range outputs {
+		readers[output]++ // Outputs must stay
+	}
+	isFactorable := func(wire string) bool {
+		gate := circuit[wire]
+		return len(gate) == 3 && gate[0] == "and" && readers[wire] == 1
+	}
+
+	wires := make([]string, 0, len(circuit))
+	for wire := range circuit {
+		wires = append(wires, wire)
+	}
+	sort.Strings(wires) // Fresh wire names must not depend on the map order
+
+	changed := false
+	for _, wire := range wires {
+		gate := circuit[wire]
+		if len(gate) != 3 || gate[0] != "xor" || gate[1] == gate[2] || !isFactorable(gate[1]) || !isFactorable(gate[2]) {
+			continue
+		}
+		p, q := circuit[gate[1]], circuit[gate[2]]
+		for i := 1; i <= 2; i++ {
+			j := 1
+			if q[2] == p[i] {
+				j = 2
+			} else if q[1] != p[i] {
+				continue
+			}
+			shared, r1, r2 := p[i], p[3-i], q[3-j]
+			delete(circuit, gate[1])
+			delete(circuit, gate[2])
+			sum := freshWire(circuit, wire)
+			circuit[sum] = []string{"xor", r1, r2}
+			circuit[wire] = []string{"and", shared, sum}
+			changed = true
+			break
+		}
+	}
+	return changed
+}
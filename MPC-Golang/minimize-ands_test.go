@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMinimizeANDs(t *testing.T) {
+	circuit, inputs, outputs, err := parseVerilog("out.v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	minimized, report := minimizeANDs(circuit, inputs, outputs)
+	if report.after > report.before || report.after != andCount(minimized) {
+		t.Errorf("out.v: %v for %d AND gates", report, andCount(minimized))
+	}
+	for wire, gate := range minimized {
+		switch {
+		case len(gate) == 0, gate[0] == "and", gate[0] == "xor", gate[0] == "not", gate[0] == "buf",
+			gate[0] == "const_0", gate[0] == "const_1":
+		default:
+			t.Errorf("%s is a %s gate", wire, gate[0])
+		}
+	}
+	assertEquivalent(t, circuit, inputs, outputs, minimized, inputs, outputs)
+
+	// (a & b) ^ (a & c) is a & (b ^ c), and a 3-input majority takes a single AND
+	b := NewCircuitBuilder()
+	x := b.InputWord("x", 3)
+	b.Output("f", b.Xor(b.And(x[0], x[1]), b.And(x[0], x[2])))
+	b.Output("m", b.Gate("lut_00010111", x[0], x[1], x[2]))
+	circuit, inputs, outputs = b.Build()
+	minimized, report = minimizeANDs(circuit, inputs, outputs)
+	if report.after != 2 {
+		t.Errorf("%v: %v", report, minimized)
+	}
+	assertEquivalent(t, circuit, inputs, outputs, minimized, inputs, outputs)
+}
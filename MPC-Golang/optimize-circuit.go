@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ___________________________________________ Circuit Optimizer _____________________________
+
+// optimizeReport holds a measure of a circuit ("gates", "AND gates", ...) before and after an optimization
+type optimizeReport struct {
+	measure       string
+	before, after int
+}
+
+func (r optimizeReport) String() string {
+	return fmt.Sprintf("%s: %d -> %d", r.measure, r.before, r.after)
+}
+
+// Function that counts the gates of a circuit, leaving out inputs and undriven wires
+func gateCount(circuit map[string][]string) int {
+	count := 0
+	for _, gate := range circuit {
+		if len(gate) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Gates whose inputs can be swapped, so that CSE can compare them in any order
+var commutativeGates = map[string]bool{
+	"and": true, "or": true, "xor": true, "nand": true, "nor": true, "xnor": true,
+}
+
+// optimizeCircuit returns a smaller circuit computing the same outputs. Walking the gates in topological order,
+// it folds constants into the gates reading them, drops inputs a gate does not depend on, merges repeated
+// inputs, absorbs NOT gates into the gates reading them when that gives a named gate (which collapses double
+// negations), bypasses buffers, and merges structurally identical gates (CSE). Gates outside the cone of the
+// outputs are removed. Inputs and outputs keep their names.
+func optimizeCircuit(circuit map[string][]string, inputs, outputs []string) (map[string][]string, optimizeReport) {
+	isInput := make(map[string]bool)
+	for _, input := range inputs {
+		isInput[input] = true
+	}
+	isOutput := make(map[string]bool)
+	for _, output := range outputs {
+		isOutput[output] = true
+	}
+
+	optimized := make(map[string][]string)
+	alias := make(map[string]string) // Removed wire -> wire with the same value
+	seen := make(map[string]string)  // CSE key of a gate -> wire it drives
+	resolve := func(wire string) string {
+		for {
+			next, aliased := alias[wire]
+			if !aliased {
+				return wire
+			}
+			wire = next
+		}
+	}
+
+	for _, wire := range topoOrder(circuit, inputs, outputs) {
+		gate := circuit[wire]
+		if isInput[wire] || len(gate) == 0 {
+			optimized[wire] = gate
+			continue
+		}
+		ins := make([]string, len(gate)-1)
+		for i, in := range gate[1:] {
+			ins[i] = resolve(in)
+		}
+		table, err := gateTruthTable(gate[0], len(ins))
+		if err != nil { // Not ours to fix, ValidateCircuit reports it
+			optimized[wire] = append([]string{gate[0]}, ins...)
+			continue
+		}
+		gate = simplifyGate(table, ins, optimized, strings.HasPrefix(gate[0], "lut_"))
+
+		key := gate[0]
+		if len(gate) > 1 {
+			keyIns := append([]string(nil), gate[1:]...)
+			if commutativeGates[gate[0]] {
+				sort.Strings(keyIns)
+			}
+			key += " " + strings.Join(keyIns, " ")
+		}
+		switch rep, found := seen[key]; {
+		case isOutput[wire]: // Outputs keep their gate, even a buf, so that they keep their name
+			optimized[wire] = gate
+			if !found {
+				seen[key] = wire
+			}
+		case gate[0] == "buf":
+			alias[wire] = gate[1]
+		case found:
+			alias[wire] = rep
+		default:
+			optimized[wire] = gate
+			seen[key] = wire
+		}
+	}
+
+	// An output buffering a gate nobody else reads takes over that gate
+	readers := make(map[string]int)
+	for _, gate := range optimized {
+		for _, in := range gate[min(1, len(gate)):] {
+			readers[in]++
+		}
+	}
+	for _, output := range outputs {
+		gate := optimized[output]
+		if len(gate) != 2 || gate[0] != "buf" || isInput[gate[1]] || isOutput[gate[1]] || readers[gate[1]] != 1 {
+			continue
+		}
+		if source := optimized[gate[1]]; len(source) > 0 {
+			optimized[output] = source
+			delete(optimized, gate[1])
+		}
+	}
+
+	// Aliasing leaves gates nobody reads any more, keep what the outputs depend on
+	live := make(map[string][]string)
+	for _, wire := range topoOrder(optimized, inputs, outputs) {
+		live[wire] = optimized[wire]
+	}
+	for _, input := range inputs {
+		live[input] = nil
+	}
+	return live, optimizeReport{"gates", gateCount(circuit), gateCount(live)}
+}
+
+// simplifyGate returns the simplest gate computing table over the wires ins, given the gates computed so far:
+// constant inputs are folded in, repeated inputs merged and inputs the table does not depend on dropped.
+// Inputs driven by a NOT gate are replaced by the negated wire when the result is a named gate (or lut is set).
+func simplifyGate(table []int, ins []string, circuit map[string][]string, lut bool) []string {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(ins) && !changed; i++ {
+			in := circuit[ins[i]]
+			switch {
+			case len(in) == 1 && (in[0] == "const_0" || in[0] == "const_1"):
+				table, ins = restrictTable(table, i, int(in[0][6]-'0')), remove(ins, i)
+				changed = true
+			case tablesEqual(restrictTable(table, i, 0), restrictTable(table, i, 1)): // Does not depend on input i
+				table, ins = restrictTable(table, i, 0), remove(ins, i)
+				changed = true
+			case len(in) == 2 && in[0] == "not":
+				negated := negateInput(table, i)
+				negatedIns := append(append(append([]string(nil), ins[:i]...), in[1]), ins[i+1:]...)
+				if _, named := tableGate(negated, negatedIns); lut || named {
+					table, ins = negated, negatedIns
+					changed = true
+				}
+			}
+			for j := i + 1; j < len(ins) && !changed; j++ {
+				if ins[i] == ins[j] { // Only the rows where both inputs are equal remain
+					table, ins = mergeInputs(table, i, j), remove(ins, j)
+					changed = true
+				}
+			}
+		}
+	}
+
+	gate, _ := tableGate(table, ins)
+	return gate
+}
+
+// tableGate returns the gate computing table over the wires ins and whether it is a named gate rather than
+// a "lut_" gate. Swapping the inputs of a 2-input gate may give a named gate (~a & b is andnot(b, a)).
+func tableGate(table []int, ins []string) ([]string, bool) {
+	if gate := lutGate(table); !strings.HasPrefix(gate, "lut_") {
+		return append([]string{gate}, ins...), true
+	}
+	if len(ins) == 2 {
+		if gate := lutGate([]int{table[0], table[2], table[1], table[3]}); !strings.HasPrefix(gate, "lut_") {
+			return []string{gate, ins[1], ins[0]}, true
+		}
+	}
+	return append([]string{lutGate(table)}, ins...), false
+}
+
+// Function that returns a copy of slice without element i
+func remove(slice []string, i int) []string {
+	return append(append([]string(nil), slice[:i]...), slice[i+1:]...)
+}
+
+func tablesEqual(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// inputBit returns the bit of the row index holding input i of a table over fanIn inputs (the first input
+// is the most significant bit)
+func inputBit(fanIn, i int) int {
+	return 1 << uint(fanIn-1-i)
+}
+
+// restrictTable returns the truth table with input i fixed to value, over the remaining inputs
+func restrictTable(table []int, i, value int) []int {
+	fanIn := tableFanIn(table)
+	bit := inputBit(fanIn, i)
+	var restricted []int
+	for row := range table {
+		if (row&bit != 0) == (value == 1) {
+			restricted = append(restricted, table[row])
+		}
+	}
+	return restricted
+}
+
+// mergeInputs returns the truth table with input j tied to input i, over the inputs without j
+func mergeInputs(table []int, i, j int) []int {
+	fanIn := tableFanIn(table)
+	bitI, bitJ := inputBit(fanIn, i), inputBit(fanIn, j)
+	var merged []int
+	for row := range table {
+		if row&bitJ == 0 {
+			if row&bitI != 0 {
+				row |= bitJ
+			}
+			merged = append(merged, table[row])
+		}
+	}
+	return merged
+}
+
+// negateInput returns the truth table with input i inverted
+func negateInput(table []int, i int) []int {
+	bit := inputBit(tableFanIn(table), i)
+	negated := make([]int, len(table))
+	for row := range table {
+		negated[row] = table[row^bit]
+	}
+	return negated
+}
+
+// Function that returns the number of inputs of a truth table
+func tableFanIn(table []int) int {
+	fanIn := 0
+	for 1<<uint(fanIn) < len(table) {
+		fanIn++
+	}
+	return fanIn
+}
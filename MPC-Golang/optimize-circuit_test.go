@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOptimizeCircuit(t *testing.T) {
+	circuit, inputs, outputs, err := parseVerilog("out.v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized, report := optimizeCircuit(circuit, inputs, outputs)
+	if report.after > report.before || report.after != gateCount(optimized) {
+		t.Errorf("out.v: %v for %d gates", report, gateCount(optimized))
+	}
+	if err := ValidateCircuit(optimized, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	assertEquivalent(t, circuit, inputs, outputs, optimized, inputs, outputs)
+
+	// Constants, double negations, buffers and repeated gates leave a gate per output
+	b := NewCircuitBuilder()
+	x, y := b.InputWord("x", 3), b.InputWord("y", 3)
+	tautology := b.Or(y[0], b.Not(y[0]))
+	b.Output("o_0", b.And(x[0], tautology))                      // x_0
+	b.Output("o_1", b.Xor(b.Not(b.Not(x[1])), b.Const(false)))   // x_1
+	b.Output("o_2", b.Xor(b.And(x[2], y[1]), b.And(y[1], x[2]))) // 0
+	b.Output("o_3", b.Gate("buf", b.Gate("buf", b.Nand(x[0], y[2]))))
+	b.Output("o_4", b.Gate("lut_10010110", x[1], x[1], y[2])) // ~y_2
+	circuit, inputs, outputs = b.Build()
+	optimized, _ = optimizeCircuit(circuit, inputs, outputs)
+	if gateCount(optimized) > 5 {
+		t.Errorf("redundant circuit optimized to %d gates: %v", gateCount(optimized), optimized)
+	}
+	assertEquivalent(t, circuit, inputs, outputs, optimized, inputs, outputs)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ___________________________________________ BLIF Parser _____________________________
+// Reads the combinational part of the Berkeley Logic Interchange Format, as written by ABC and Yosys:
+//
+//	.model adder
+//	.inputs a b
+//	.outputs s
+//	.names a b s   # one lookup-table gate per .names block
+//	01 1
+//	10 1
+//	.end
+//
+// Every .names block becomes the named gate of labelTruthTable matching its cover, or a "lut_" gate
+// with the full truth table. Only the first model is read, up to its .end or the next .model.
+
+func parseBLIF(filename string) (map[string][]string, []string, []string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+
+	circuit := make(map[string][]string)
+	var inputs, outputs []string
+	var names []string // Wires of the open .names block, output last
+	var cover []string // Its cover lines
+	model := false     // Whether a .model line was read
+	lineNo := 0
+
+	// closeNames turns the cover of the open .names block into a gate
+	closeNames := func() error {
+		if names == nil {
+			return nil
+		}
+		ins, out := names[:len(names)-1], names[len(names)-1]
+		table, err := blifTruthTable(len(ins), cover)
+		if err != nil {
+			return fmt.Errorf("%s:%d: .names %s: %v", filename, lineNo, out, err)
+		}
+		if _, isInput := find(inputs, out); isInput {
+			return fmt.Errorf("%s:%d: .names drives input %s", filename, lineNo, out)
+		}
+		if circuit[out] != nil {
+			return fmt.Errorf("%s:%d: wire %s has multiple drivers", filename, lineNo, out)
+		}
+		circuit[out] = append([]string{lutGate(table)}, ins...)
+		names, cover = nil, nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		for strings.HasSuffix(line, "\\") && scanner.Scan() { // Continued on the next line
+			lineNo++
+			line = line[:len(line)-1] + " " + scanner.Text()
+		}
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if !strings.HasPrefix(fields[0], ".") { // A cover line of the open .names block
+			if names == nil {
+				return nil, nil, nil, fmt.Errorf("%s:%d: cover line outside of .names", filename, lineNo)
+			}
+			cover = append(cover, strings.Join(fields, " "))
+			continue
+		}
+		if err := closeNames(); err != nil {
+			return nil, nil, nil, err
+		}
+
+		switch fields[0] {
+		case ".model":
+			if model { // The next model, without an .end before it
+				return circuit, inputs, outputs, nil
+			}
+			model = true
+		case ".inputs":
+			for _, input := range fields[1:] {
+				if circuit[input] != nil {
+					return nil, nil, nil, fmt.Errorf("%s:%d: .names drives input %s", filename, lineNo, input)
+				}
+				inputs = append(inputs, input)
+				circuit[input] = nil
+			}
+		case ".outputs":
+			outputs = append(outputs, fields[1:]...)
+		case ".names":
+			if len(fields) < 2 {
+				return nil, nil, nil, fmt.Errorf("%s:%d: .names without an output", filename, lineNo)
+			}
+			names = fields[1:]
+		case ".end":
+			return circuit, inputs, outputs, nil
+		default: // .latch, .subckt, .gate, ...
+			return nil, nil, nil, fmt.Errorf("%s:%d: unsupported %s", filename, lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := closeNames(); err != nil {
+		return nil, nil, nil, err
+	}
+	return circuit, inputs, outputs, nil
+}
+
+// blifTruthTable expands the cover of a .names block ("1-0 1" lines) into a truth table.
+// The cover lists either the on-set (output 1) or the off-set (output 0); an empty cover is constant 0.
+func blifTruthTable(fanIn int, cover []string) ([]int, error) {
+	table := make([]int, 1<<fanIn)
+	var coverOutput string
+	for i, line := range cover {
+		fields := strings.Fields(line)
+		pattern, output := "", ""
+		if fanIn == 0 && len(fields) == 1 {
+			output = fields[0]
+		} else if len(fields) == 2 {
+			pattern, output = fields[0], fields[1]
+		}
+		if len(pattern) != fanIn || (output != "0" && output != "1") || strings.Trim(pattern, "01-") != "" {
+			return nil, fmt.Errorf("invalid cover line %q", line)
+		}
+		if i == 0 {
+			coverOutput = output
+			if output == "0" { // Off-set: everything not covered is 1
+				for row := range table {
+					table[row] = 1
+				}
+			}
+		} else if output != coverOutput {
+			return nil, fmt.Errorf("cover mixes on-set and off-set lines")
+		}
+
+		for row := range table {
+			matches := true
+			for j, c := range pattern { // The first input is the most significant bit of the row
+				bit := (row >> (fanIn - 1 - j)) & 1
+				if c != '-' && int(c-'0') != bit {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				table[row] = int(output[0] - '0')
+			}
+		}
+	}
+	return table, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A full adder with a nand written as an off-set, constants and a continued line
+const blifFullAdder = `# Full adder
+.model full_adder
+.inputs a b \
+  cin
+.outputs s cout n one zero
+.names a b cin s
+100 1
+010 1
+001 1
+111 1
+.names a b cin cout
+11- 1
+1-1 1
+-11 1
+.names a b n
+11 0
+.names one
+1
+.names zero
+.end
+`
+
+func TestParseBLIF(t *testing.T) {
+	circuit, inputs, outputs, err := parseBLIF(writeTestFile(t, "full_adder.blif", blifFullAdder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "cin"}; !reflect.DeepEqual(inputs, want) {
+		t.Errorf("inputs %v, want %v", inputs, want)
+	}
+	for wire, want := range map[string][]string{
+		"s":    {"lut_01101001", "a", "b", "cin"},
+		"cout": {"lut_00010111", "a", "b", "cin"},
+		"n":    {"nand", "a", "b"},
+		"one":  {"const_1"},
+		"zero": {"const_0"},
+	} {
+		if !reflect.DeepEqual(circuit[wire], want) {
+			t.Errorf("%s = %v, want %v", wire, circuit[wire], want)
+		}
+	}
+	if err := ValidateCircuit(circuit, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second model without an .end before it is not read
+	twoModels := ".model m\n.inputs a\n.outputs o\n.names a o\n1 1\n.model n\n.inputs b\n.outputs p\n.names b p\n0 1\n"
+	circuit, _, outputs, err = parseBLIF(writeTestFile(t, "two_models.blif", twoModels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := circuit["p"]; found || !reflect.DeepEqual(outputs, []string{"o"}) {
+		t.Errorf("read the second model: %v with outputs %v", circuit, outputs)
+	}
+
+	for _, bad := range []string{
+		".model m\n.inputs a\n.outputs o\n.names a o\n1 1\n0 0\n.end\n",               // Mixed on-set and off-set
+		".model m\n.inputs a\n.outputs o\n.latch a o\n.end\n",                         // Sequential
+		".model m\n.inputs a\n.outputs o\n1 1\n.end\n",                                // Cover line outside of .names
+		".model m\n.inputs a b\n.outputs o\n.names a b\n1 1\n.names b o\n1 1\n.end\n", // .names driving an input
+		".model m\n.outputs o\n.names o\n1\n.inputs o\n.end\n",                        // Input driven by an earlier .names
+	} {
+		if _, _, _, err := parseBLIF(writeTestFile(t, "bad.blif", bad)); err == nil {
+			t.Errorf("no error reading %q", bad)
+		}
+	}
+}
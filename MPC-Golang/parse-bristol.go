@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ___________________________________________ Bristol Fashion Reader _____________________________
+// Reads circuits in the Bristol Fashion format (SCALE-MAMBA, EMP, MP-SPDZ):
+//
+//	<gates> <wires>
+//	<input values> <bits of value 0> <bits of value 1> ...
+//	<output values> <bits of value 0> ...
+//
+//	2 1 <in> <in> <out> AND|XOR
+//	1 1 <in> <out> INV|EQW
+//	1 1 <0|1> <out> EQ
+//	<2n> <n> <in>... <out>... MAND
+//
+// Input values take the first wires and output values the last ones, least significant bit first.
+// Input value 0 becomes the wires x_0, x_1, ..., value 1 the wires y_0, ... (see bristolInputPrefix),
+// so wireValues can encode integers into them. Outputs are named out_0, ... or out0_0, out1_0, ...
+// when the circuit has several output values. Every other wire w is named w<w>.
+
+// maxBristolWires bounds the wire count of a header, which readBristol allocates names for before reading any gate
+const maxBristolWires = 1 << 26
+
+// bristolInputPrefix returns the wire prefix of input value i: x, y, z, in3, in4, ...
+func bristolInputPrefix(i int) string {
+	if i < 3 {
+		return string("xyz"[i])
+	}
+	return fmt.Sprintf("in%d", i)
+}
+
+func parseBristol(filename string) (map[string][]string, []string, []string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+	return readBristol(f)
+}
+
+// Function that reads a Bristol Fashion circuit into the circuit map consumed by garbleCircuit
+func readBristol(r io.Reader) (map[string][]string, []string, []string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // MAND lines can get long
+	lineNo := 0
+
+	// nextLine returns the fields of the next non-empty line, or nil at the end of the file
+	nextLine := func() ([]int, []string, error) {
+		for scanner.Scan() {
+			lineNo++
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			numbers := make([]int, 0, len(fields))
+			for _, field := range fields {
+				n, err := strconv.Atoi(field)
+				if err != nil {
+					break // The gate type
+				}
+				numbers = append(numbers, n)
+			}
+			return numbers, fields, nil
+		}
+		return nil, nil, scanner.Err()
+	}
+
+	// Header: gate and wire counts, then the input and output value sizes
+	var header [3][]int
+	for i := range header {
+		numbers, fields, err := nextLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if fields == nil || len(numbers) != len(fields) || len(numbers) < 1 ||
+			(i == 0 && len(numbers) != 2) || (i > 0 && len(numbers) != numbers[0]+1) {
+			return nil, nil, nil, fmt.Errorf("line %d: invalid Bristol Fashion header", lineNo)
+		}
+		for _, n := range numbers {
+			if n < 0 {
+				return nil, nil, nil, fmt.Errorf("line %d: negative count %d in the header", lineNo, n)
+			}
+		}
+		header[i] = numbers
+	}
+	numGates, numWires := header[0][0], header[0][1]
+	if numWires > maxBristolWires {
+		return nil, nil, nil, fmt.Errorf("circuit has %d wires, more than the %d supported", numWires, maxBristolWires)
+	}
+	total := 0
+	for _, values := range header[1:] {
+		for _, bits := range values[1:] {
+			if total += bits; bits > numWires || total > numWires {
+				return nil, nil, nil, fmt.Errorf("circuit has %d wires, too few for its input and output values", numWires)
+			}
+		}
+	}
+
+	names := make([]string, numWires)
+	var inputs, outputs []string
+	wire := 0
+	for i, bits := range header[1][1:] {
+		for j := 0; j < bits; j++ {
+			names[wire] = fmt.Sprintf("%s_%d", bristolInputPrefix(i), j)
+			inputs = append(inputs, names[wire])
+			wire++
+		}
+	}
+	wire = numWires - (total - len(inputs))
+	for i, bits := range header[2][1:] {
+		prefix := "out"
+		if header[2][0] > 1 {
+			prefix = fmt.Sprintf("out%d", i)
+		}
+		for j := 0; j < bits; j++ {
+			names[wire] = fmt.Sprintf("%s_%d", prefix, j)
+			outputs = append(outputs, names[wire])
+			wire++
+		}
+	}
+	for w := range names {
+		if names[w] == "" {
+			names[w] = fmt.Sprintf("w%d", w)
+		}
+	}
+
+	circuit := make(map[string][]string)
+	for _, input := range inputs {
+		circuit[input] = nil
+	}
+	for gates := 0; ; gates++ {
+		numbers, fields, err := nextLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if fields == nil {
+			if gates != numGates {
+				return nil, nil, nil, fmt.Errorf("expected %d gates, found %d", numGates, gates)
+			}
+			break
+		}
+
+		if len(numbers) < 2 || numbers[0] < 0 || numbers[1] < 0 || len(fields) != len(numbers)+1 ||
+			len(numbers) != numbers[0]+numbers[1]+2 {
+			return nil, nil, nil, fmt.Errorf("line %d: malformed gate", lineNo)
+		}
+		op, ins, outs := fields[len(fields)-1], numbers[2:2+numbers[0]], numbers[2+numbers[0]:]
+		wires := numbers[2:]
+		if op == "EQ" { // The input of EQ is a constant rather than a wire
+			wires = outs
+		}
+		for _, w := range wires {
+			if w < 0 || w >= numWires {
+				return nil, nil, nil, fmt.Errorf("line %d: wire %d out of range", lineNo, w)
+			}
+		}
+		for _, w := range outs {
+			if circuit[names[w]] != nil || w < len(inputs) {
+				return nil, nil, nil, fmt.Errorf("line %d: wire %d has multiple drivers", lineNo, w)
+			}
+		}
+
+		var gate string
+		switch {
+		case op == "AND" && len(ins) == 2 && len(outs) == 1:
+			gate = "and"
+		case op == "XOR" && len(ins) == 2 && len(outs) == 1:
+			gate = "xor"
+		case op == "INV" && len(ins) == 1 && len(outs) == 1:
+			gate = "not"
+		case op == "EQW" && len(ins) == 1 && len(outs) == 1:
+			gate = "buf"
+		case op == "EQ" && len(ins) == 1 && len(outs) == 1 && (ins[0] == 0 || ins[0] == 1):
+			circuit[names[outs[0]]] = []string{fmt.Sprintf("const_%d", ins[0])}
+			continue
+		case op == "MAND" && len(ins) == 2*len(outs): // Several AND gates: a_i & b_i -> c_i
+			for i, out := range outs {
+				circuit[names[out]] = []string{"and", names[ins[i]], names[ins[len(outs)+i]]}
+			}
+			continue
+		default:
+			return nil, nil, nil, fmt.Errorf("line %d: unsupported gate %s with %d inputs and %d outputs", lineNo, op, len(ins), len(outs))
+		}
+		gateList := []string{gate}
+		for _, in := range ins {
+			gateList = append(gateList, names[in])
+		}
+		circuit[names[outs[0]]] = gateList
+	}
+
+	return circuit, inputs, outputs, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// A 2 bit adder with a 3 bit sum and a second output of ~x_0 and x_1, using every gate type
+const bristolAdder = `10 15
+2 2 2
+2 3 2
+
+4 2 0 1 2 3 4 5 MAND
+2 1 1 3 6 XOR
+2 1 6 4 7 AND
+2 1 0 2 8 XOR
+1 1 0 9 EQ
+1 1 8 10 EQW
+2 1 6 4 11 XOR
+2 1 5 7 12 XOR
+1 1 0 13 INV
+2 1 9 1 14 XOR
+`
+
+func TestReadBristol(t *testing.T) {
+	circuit, inputs, outputs, err := readBristol(strings.NewReader(bristolAdder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCircuit(circuit, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	for x := int64(0); x < 4; x++ {
+		for y := int64(0); y < 4; y++ {
+			got, err := simulateCircuitWords(circuit, inputs, outputs, map[string]*big.Int{"x": big.NewInt(x), "y": big.NewInt(y)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["out0"].Int64() != x+y || got["out1"].Int64() != x&2|^x&1 {
+				t.Errorf("x=%d y=%d gives %v %v, want %d %d", x, y, got["out0"], got["out1"], x+y, x&2|^x&1)
+			}
+		}
+	}
+
+	for _, bad := range []string{
+		"1 3\n2 1 1\n1 1\n2 1 0 1 5 AND\n",                // Wire out of range
+		"2 3\n2 1 1\n1 1\n2 1 0 1 2 AND\n2 1 0 1 2 XOR\n", // Multiple drivers
+		"1 3\n2 1 1\n1 1\n2 1 0 1 2 FOO\n",                // Unknown gate
+		"2 3\n2 1 1\n1 1\n2 1 0 1 2 XOR\n",                // Fewer gates than announced
+		"1 3\n2 1 1\n1 1\n2 1 0 1 2 AND extra\n",          // Malformed gate
+		"1 -5\n2 1 1\n1 1\n2 1 0 1 2 AND\n",               // Negative wire count
+		"1 3\n2 1 1\n1 -1\n2 1 0 1 2 AND\n",               // Negative output size
+		"1 3\n2 1 1\n1 1\n-1 3 0 0 AND\n",                 // Negative input count of a gate
+		"1 3\n2 1 1\n1 9\n2 1 0 1 2 AND\n",                // More input and output bits than wires
+		"1 1000000000000\n2 1 1\n1 1\n2 1 0 1 2 AND\n",    // Oversized wire count
+	} {
+		if _, _, _, err := readBristol(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error reading %q", bad)
+		}
+	}
+}
@@ -1,66 +1,1405 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
-	"io/ioutil"
-	"regexp"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // ___________________________________________ Verilog Parser Function_____________________________
-func parseVerilog(filename string) (map[string]interface{}, []string, []string, error) {
-	circuit := make(map[string]interface{}) // Map from wire name -> operation
-	var inputs, outputs []string
+func parseVerilog(filename string) (map[string][]string, []string, []string, error) {
+	circuit, inputs, outputs, _, err := parseVerilogBuses(filename)
+	return circuit, inputs, outputs, err
+}
+
+// parseVerilogBuses parses like parseVerilog and also returns the bus -> bit wires mapping
+// (least significant bit first) of every vector declaration. Bit i of bus x is the wire x_i, counting from
+// the declared least significant bit: both x[7:0] and x[0:7] have x_0 as their lsb, and x[8:1] runs x_0 to x_7.
+//
+// Modules instantiated by other modules in the file are flattened into the top module (the last one
+// nobody instantiates): the wires of instance a1 are prefixed with "a1." and its ports are connected
+// to the wires of the instantiating module.
+//
+// Errors are reported as file:line:col; the returned error is an errorList with every problem found.
+func parseVerilogBuses(filename string) (map[string][]string, []string, []string, map[string][]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	defer f.Close()
+
+	var errs errorList
+	p := newVerilogParser(f, filename, &errs)
+	p.parseFile()
+	if len(errs) > 0 { // Elaborating a module with syntax errors only adds confusing errors
+		return nil, nil, nil, nil, errs
+	}
+
+	// The top module is the last one that is not instantiated anywhere else
+	instantiated := make(map[string]bool)
+	for _, def := range p.modules {
+		for _, stmt := range def.statements {
+			if stmt.kind == "instance" && stmt.typ != def.name { // Instantiating itself is an error of elaborate
+				instantiated[stmt.typ] = true
+			}
+		}
+	}
+	var top *verilogModuleDef
+	for _, name := range p.order {
+		if !instantiated[name] {
+			top = p.modules[name]
+		}
+	}
+	if top == nil {
+		return nil, nil, nil, nil, fmt.Errorf("%s: no top module", filename)
+	}
+
+	net := newVerilogNetlist(p)
+	net.elaborate(top, newVerilogScope(""), nil)
+	if len(errs) > 0 {
+		return nil, nil, nil, nil, errs
+	}
+	return net.circuit, net.inputs, net.outputs, net.buses, nil
+}
+
+// streamVerilog parses Verilog from r statement by statement and hands the gates of the top module to emit
+// instead of collecting the circuit map, in a topological order: a gate is emitted once every wire it reads is
+// an input or was emitted, so it may wait for statements further down. Memory is not bounded: the source text
+// and the emitted gates are dropped, but every wire name stays in the declarations, the identifiers and the set
+// of emitted wires, and gates waiting for their inputs stay until they are emitted (a file assigning its wires in
+// reverse order holds every gate until the end). streamVerilog saves the circuit map, not the netlist's size.
+// filename is used in error positions. Intermediate wires of expressions are named to avoid the identifiers read
+// so far, so a name used only further down may still clash with one.
+//
+// The top module is the module named top, which may only be "" when the file has a single module. Modules it
+// instantiates must be defined before it. Gates reading wires that are never driven are emitted at the end;
+// gates left on a combinational cycle are an error. An error returned by emit stops the parse and is returned as is.
+func streamVerilog(r io.Reader, filename, top string, emit func(wire string, gate []string) error) ([]string, []string, error) {
+	var errs errorList
+	var emitErr error
+	p := newVerilogParser(r, filename, &errs)
+	net := newVerilogNetlist(p)
+	net.emitted = make(map[string]bool)
+	scope := newVerilogScope("")
+
+	ready := make(map[string]bool)       // Inputs and emitted wires
+	pending := make(map[string][]string) // Gates waiting for some of their inputs
+	missing := make(map[string]int)      // Pending wire -> number of its inputs that are not ready
+	waiters := make(map[string][]string) // Wire that is not ready -> pending gates reading it
+	markReady := func(wire string) error {
+		for queue := []string{wire}; len(queue) > 0; queue = queue[1:] {
+			if ready[queue[0]] {
+				continue
+			}
+			ready[queue[0]] = true
+			for _, waiter := range waiters[queue[0]] {
+				if missing[waiter]--; missing[waiter] > 0 {
+					continue
+				}
+				if err := emit(waiter, pending[waiter]); err != nil {
+					return err
+				}
+				delete(pending, waiter)
+				delete(missing, waiter)
+				queue = append(queue, waiter)
+			}
+			delete(waiters, queue[0])
+		}
+		return nil
+	}
+	addGate := func(wire string, gate []string) error {
+		read := make(map[string]bool)
+		for _, in := range gate[1:] {
+			if !ready[in] && !read[in] {
+				read[in] = true
+				waiters[in] = append(waiters[in], wire)
+			}
+		}
+		if len(read) > 0 {
+			pending[wire], missing[wire] = gate, len(read)
+			return nil
+		}
+		if err := emit(wire, gate); err != nil {
+			return err
+		}
+		return markReady(wire)
+	}
+
+	named := top != ""
+	inputs := 0 // Inputs of net.inputs already marked ready
+	p.stream = func(def *verilogModuleDef, stmt *verilogStmt) bool {
+		if top == "" {
+			top = def.name
+		}
+		if def.name != top {
+			return false
+		}
+		if len(errs) > 0 { // Keep parsing for more syntax errors, but the netlist is lost anyway
+			return true
+		}
+		net.elaborateStatement(scope, stmt, []string{top})
+		for ; inputs < len(net.inputs) && emitErr == nil; inputs++ {
+			emitErr = markReady(net.inputs[inputs])
+		}
+		wires := make([]string, 0, len(net.circuit))
+		for wire, gate := range net.circuit {
+			if gate != nil {
+				wires = append(wires, wire)
+			}
+		}
+		sort.Strings(wires)
+		for _, wire := range wires {
+			if emitErr != nil {
+				break
+			}
+			net.emitted[wire] = true
+			emitErr = addGate(wire, net.circuit[wire])
+		}
+		if emitErr != nil {
+			p.aborted = true
+		}
+		net.circuit = make(map[string][]string)
+		return true
+	}
+	p.parseFile()
+
+	if emitErr != nil {
+		return nil, nil, emitErr
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	if !named && len(p.order) > 1 { // The top module would be the last one nobody instantiates, known only now
+		return nil, nil, fmt.Errorf("%s: %d modules, the top module must be named", filename, len(p.order))
+	}
+	if !named && len(p.order) == 1 {
+		top = p.order[0]
+	}
+	if _, found := p.modules[top]; !found {
+		return nil, nil, fmt.Errorf("%s: no module %s", filename, top)
+	}
+
+	// What still waits reads wires nobody drives, or is on a cycle
+	var undriven []string
+	for wire := range waiters {
+		if _, isGate := pending[wire]; !isGate {
+			undriven = append(undriven, wire)
+		}
+	}
+	sort.Strings(undriven)
+	for _, wire := range undriven {
+		if err := markReady(wire); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(pending) > 0 {
+		cycle := make([]string, 0, len(pending))
+		for wire := range pending {
+			cycle = append(cycle, wire)
+		}
+		sort.Strings(cycle)
+		return nil, nil, fmt.Errorf("%s: combinational cycle through %s", filename, strings.Join(cycle, ", "))
+	}
+	return net.inputs, net.outputs, nil
+}
+
+// ___________________________________________ Diagnostics _____________________________
+
+// verilogPos is a position in a Verilog source file
+type verilogPos struct {
+	file      string
+	line, col int
+}
+
+func (p verilogPos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.col)
+}
+
+// verilogError is an error at a position of the source
+type verilogError struct {
+	pos verilogPos
+	msg string
+}
+
+func (e *verilogError) Error() string {
+	return e.pos.String() + ": " + e.msg
+}
+
+func errorAt(pos verilogPos, format string, args ...interface{}) error {
+	return &verilogError{pos, fmt.Sprintf(format, args...)}
+}
+
+// errorList collects several errors, reported one per line
+type errorList []error
+
+// Stop collecting after this many errors, the rest are usually follow-up errors
+const maxErrors = 50
+
+func (l errorList) Error() string {
+	lines := make([]string, len(l))
+	for i, err := range l {
+		lines[i] = err.Error()
+	}
+	if len(l) >= maxErrors {
+		lines = append(lines, "too many errors")
+	}
+	return strings.Join(lines, "\n")
+}
 
-	data, err := ioutil.ReadFile(filename)
+func (l *errorList) add(err error) {
+	if len(*l) < maxErrors {
+		*l = append(*l, err)
+	}
+}
+
+// ___________________________________________ Tokenizer _____________________________
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokOp
+)
+
+type verilogToken struct {
+	kind    int
+	text    string // Escaped identifiers (\a1.s ) without the backslash and the terminating space
+	pos     verilogPos
+	escaped bool // An escaped identifier, never a keyword or gate primitive (\wire , \and )
+}
+
+func (t verilogToken) String() string {
+	if t.kind == tokEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// verilogLexer splits Verilog source into tokens, skipping white space and // and /* */ comments
+type verilogLexer struct {
+	r       *bufio.Reader
+	pos     verilogPos // Position of the next rune
+	lastPos verilogPos // Position before the last read, for unread
+	errs    *errorList
+	idents  map[string]bool // Every identifier read, so that intermediate wires can avoid their names
+}
+
+func (l *verilogLexer) read() rune {
+	c, _, err := l.r.ReadRune()
 	if err != nil {
-		return nil, nil, nil, err
+		if err != io.EOF {
+			l.errs.add(errorAt(l.pos, "%v", err))
+		}
+		return -1
+	}
+	l.lastPos = l.pos
+	if c == '\n' {
+		l.pos.line++
+		l.pos.col = 1
+	} else {
+		l.pos.col++
 	}
+	return c
+}
 
-	filecontents := string(data)
-	lines := strings.Split(filecontents, ";")
+func (l *verilogLexer) unread(c rune) {
+	if c >= 0 {
+		l.r.UnreadRune()
+		l.pos = l.lastPos
+	}
+}
 
-	commentRegex := regexp.MustCompile(`(/\*.*?\*/)|(//.*$)`)
-	for _, l := range lines {
-		if l == "" {
+func isIdentStart(c rune) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c rune) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '$'
+}
+
+// readWhile appends the runes accepted by ok to text
+func (l *verilogLexer) readWhile(text []rune, ok func(c rune) bool) []rune {
+	for {
+		c := l.read()
+		if c < 0 || !ok(c) {
+			l.unread(c)
+			return text
+		}
+		text = append(text, c)
+	}
+}
+
+func (l *verilogLexer) next() verilogToken {
+	for {
+		pos := l.pos
+		c := l.read()
+		switch {
+		case c < 0:
+			return verilogToken{kind: tokEOF, pos: pos}
+		case unicode.IsSpace(c):
 			continue
+		case c == '/':
+			switch next := l.read(); next {
+			case '/':
+				l.readWhile(nil, func(c rune) bool { return c != '\n' })
+				continue
+			case '*':
+				for prev := rune(0); ; {
+					c := l.read()
+					if c < 0 {
+						l.errs.add(errorAt(pos, "unterminated /* comment"))
+						return verilogToken{kind: tokEOF, pos: l.pos}
+					}
+					if prev == '*' && c == '/' {
+						break
+					}
+					prev = c
+				}
+				continue
+			default:
+				l.unread(next)
+				return verilogToken{kind: tokOp, text: "/", pos: pos}
+			}
+		case isIdentStart(c):
+			text := string(l.readWhile([]rune{c}, isIdentPart))
+			l.idents[text] = true
+			return verilogToken{kind: tokIdent, text: text, pos: pos}
+		case c == '\\': // Escaped identifier, up to the next white space
+			text := l.readWhile(nil, func(c rune) bool { return !unicode.IsSpace(c) })
+			if len(text) == 0 {
+				l.errs.add(errorAt(pos, "empty escaped identifier"))
+				continue
+			}
+			l.idents[string(text)] = true
+			return verilogToken{kind: tokIdent, text: string(text), pos: pos, escaped: true}
+		case c >= '0' && c <= '9' || c == '\'': // 42, 32'd9001, 1'b0, 'h1f
+			text := l.readWhile([]rune{c}, func(c rune) bool { return c >= '0' && c <= '9' || c == '_' })
+			if c != '\'' {
+				quote := l.read()
+				if quote != '\'' {
+					l.unread(quote)
+					return verilogToken{kind: tokNumber, text: string(text), pos: pos}
+				}
+				text = append(text, quote)
+			}
+			text = l.readWhile(text, func(c rune) bool { return c == 's' || c == 'S' })
+			if base := l.read(); strings.ContainsRune("bBoOdDhH", base) {
+				text = append(text, base)
+			} else {
+				l.unread(base)
+			}
+			text = l.readWhile(text, func(c rune) bool { return unicode.IsDigit(c) || strings.ContainsRune("abcdefABCDEF_xXzZ?", c) })
+			return verilogToken{kind: tokNumber, text: string(text), pos: pos}
+		case c == '~' || c == '^': // ~^ and ^~ are XNOR
+			next := l.read()
+			if (c == '~' && next == '^') || (c == '^' && next == '~') {
+				return verilogToken{kind: tokOp, text: string([]rune{c, next}), pos: pos}
+			}
+			l.unread(next)
+			return verilogToken{kind: tokOp, text: string(c), pos: pos}
+		case strings.ContainsRune("&|()[]:{},;=.#", c):
+			return verilogToken{kind: tokOp, text: string(c), pos: pos}
+		default:
+			l.errs.add(errorAt(pos, "unexpected character %q", c))
+		}
+	}
+}
+
+// ___________________________________________ Parser _____________________________
+
+// verilogStmt is a module item: a declaration, a single assignment or a module or gate instance
+type verilogStmt struct {
+	pos    verilogPos
+	kind   string // "input", "output", "wire", "assign" or "instance"
+	names  []string
+	rng    *[2]int // Declared [msb, lsb] of vectors
+	lhs    *verilogExpr
+	rhs    *verilogExpr
+	typ    string // Instantiated module or gate primitive
+	module bool   // typ is an escaped identifier, a module even if named like a gate primitive
+	name   string // Instance name, optional for gate primitives
+	conns  []verilogConn
+}
+
+// verilogConn connects an expression to a port, by name (.a(x)) or by position
+type verilogConn struct {
+	port string
+	expr *verilogExpr // nil when left unconnected
+	pos  verilogPos
+}
+
+// verilogModuleDef is a module definition, elaborated once per instance
+type verilogModuleDef struct {
+	name       string
+	pos        verilogPos
+	portOrder  []string        // Port names in header order, for positional connections
+	ports      map[string]bool // Port names
+	statements []*verilogStmt
+}
+
+// verilogParser is a recursive descent parser over the tokens of a Verilog file
+type verilogParser struct {
+	lex     *verilogLexer
+	tok     verilogToken // The current token
+	errs    *errorList
+	modules map[string]*verilogModuleDef
+	order   []string // Module names in definition order
+
+	// stream, when set, gets every statement as soon as it is parsed and tells whether it consumed it;
+	// statements it does not consume are kept in the definition of their module
+	stream  func(def *verilogModuleDef, stmt *verilogStmt) bool
+	aborted bool // Stops parsing as if the file ended, after stream failed
+}
+
+func newVerilogParser(r io.Reader, filename string, errs *errorList) *verilogParser {
+	lex := &verilogLexer{r: bufio.NewReader(r), pos: verilogPos{filename, 1, 1}, errs: errs, idents: make(map[string]bool)}
+	p := &verilogParser{lex: lex, errs: errs, modules: make(map[string]*verilogModuleDef)}
+	p.advance()
+	return p
+}
+
+func (p *verilogParser) advance() {
+	if p.aborted {
+		p.tok = verilogToken{kind: tokEOF, pos: p.tok.pos}
+		return
+	}
+	p.tok = p.lex.next()
+}
+
+// add adds a statement to a module definition, unless stream consumes it
+func (p *verilogParser) add(def *verilogModuleDef, stmt *verilogStmt) {
+	if p.stream == nil || !p.stream(def, stmt) {
+		def.statements = append(def.statements, stmt)
+	}
+}
+
+// is reports whether the current token is the operator or keyword text
+func (p *verilogParser) is(text string) bool {
+	return (p.tok.kind == tokOp || p.tok.kind == tokIdent) && !p.tok.escaped && p.tok.text == text
+}
+
+// expect consumes the current token, which must be the operator or keyword text
+func (p *verilogParser) expect(text string) error {
+	if !p.is(text) {
+		return errorAt(p.tok.pos, "expected '%s', found %v", text, p.tok)
+	}
+	p.advance()
+	return nil
+}
+
+// ident consumes an identifier and returns it
+func (p *verilogParser) ident(what string) (string, error) {
+	if p.tok.kind != tokIdent {
+		return "", errorAt(p.tok.pos, "expected %s, found %v", what, p.tok)
+	}
+	name := p.tok.text
+	p.advance()
+	return name, nil
+}
+
+// number consumes an unsized decimal number such as a select index
+func (p *verilogParser) number() (int, error) {
+	value, err := strconv.Atoi(p.tok.text)
+	if p.tok.kind != tokNumber || err != nil {
+		return 0, errorAt(p.tok.pos, "expected a number, found %v", p.tok)
+	}
+	p.advance()
+	return value, nil
+}
+
+// skipStatement recovers from a syntax error by skipping past the next ';'
+func (p *verilogParser) skipStatement() {
+	for p.tok.kind != tokEOF && !p.is("endmodule") && !p.is("module") {
+		semicolon := p.is(";")
+		p.advance()
+		if semicolon {
+			return
 		}
-		l = commentRegex.ReplaceAllString(l, "") // Remove comments
-		l = strings.TrimSpace(l)
-		tokens := strings.Fields(l)
-		if len(tokens) == 0 || tokens[0] == "module" || tokens[0] == "endmodule" {
+	}
+}
+
+func (p *verilogParser) parseFile() {
+	for p.tok.kind != tokEOF {
+		if !p.is("module") {
+			p.errs.add(errorAt(p.tok.pos, "expected 'module', found %v", p.tok))
+			for p.tok.kind != tokEOF && !p.is("module") {
+				p.advance()
+			}
 			continue
 		}
-		tokens[len(tokens)-1] = strings.TrimRight(tokens[len(tokens)-1], ";")
+		p.parseModule()
+	}
+}
+
+func (p *verilogParser) parseModule() {
+	def := &verilogModuleDef{pos: p.tok.pos, ports: make(map[string]bool)}
+	p.advance()
+	if err := p.parseModuleHeader(def); err != nil {
+		p.errs.add(err)
+		p.skipStatement()
+	}
+	if def.name != "" {
+		if _, exists := p.modules[def.name]; exists {
+			p.errs.add(errorAt(def.pos, "module %s defined twice", def.name))
+		}
+		p.modules[def.name] = def
+		p.order = append(p.order, def.name)
+	}
+
+	for !p.is("endmodule") {
+		if p.tok.kind == tokEOF || p.is("module") {
+			p.errs.add(errorAt(p.tok.pos, "missing 'endmodule' of module %s", def.name))
+			return
+		}
+		statements, err := p.parseStatement()
+		if err != nil {
+			p.errs.add(err)
+			p.skipStatement()
+			continue
+		}
+		for _, stmt := range statements {
+			p.add(def, stmt)
+		}
+	}
+	p.advance()
+}
+
+// parseModuleHeader parses "module adder(a, b, s);" or the ANSI style "module adder(input [3:0] a, b, output s);".
+// ANSI port declarations become declaration statements of the module.
+func (p *verilogParser) parseModuleHeader(def *verilogModuleDef) error {
+	var err error
+	if def.name, err = p.ident("module name"); err != nil {
+		return err
+	}
+	if p.is("(") {
+		p.advance()
+		var decl *verilogStmt // Direction and range of the last ANSI port, inherited by the ports following it
+		for !p.is(")") {
+			pos := p.tok.pos
+			if p.is("input") || p.is("output") {
+				if decl, err = p.parseDeclType(); err != nil {
+					return err
+				}
+			}
+			port, err := p.ident("port name")
+			if err != nil {
+				return err
+			}
+			if decl != nil {
+				p.add(def, &verilogStmt{pos: pos, kind: decl.kind, rng: decl.rng, names: []string{port}})
+			}
+			def.portOrder = append(def.portOrder, port)
+			def.ports[port] = true
+			if !p.is(",") {
+				break
+			}
+			p.advance()
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+	}
+	return p.expect(";")
+}
+
+// parseDeclType parses "input", "output wire [3:0]", "wire [7:0]", ...
+func (p *verilogParser) parseDeclType() (*verilogStmt, error) {
+	stmt := &verilogStmt{pos: p.tok.pos, kind: p.tok.text}
+	p.advance()
+	if stmt.kind != "wire" && p.is("wire") {
+		p.advance()
+	}
+	if p.is("[") {
+		p.advance()
+		msb, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		lsb, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		stmt.rng = &[2]int{msb, lsb}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *verilogParser) parseStatement() ([]*verilogStmt, error) {
+	pos := p.tok.pos
+	switch {
+	case p.is("input") || p.is("output") || p.is("wire"): // Declaration
+		stmt, err := p.parseDeclType()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.ident("wire name")
+			if err != nil {
+				return nil, err
+			}
+			stmt.names = append(stmt.names, name)
+			if !p.is(",") {
+				break
+			}
+			p.advance()
+		}
+		return []*verilogStmt{stmt}, p.expect(";")
+
+	case p.is("assign"): // Assignment, "assign a = x, b = y;"
+		p.advance()
+		var statements []*verilogStmt
+		for {
+			stmt := &verilogStmt{pos: p.tok.pos, kind: "assign"}
+			var err error
+			if stmt.lhs, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			if stmt.rhs, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+			statements = append(statements, stmt)
+			if !p.is(",") {
+				break
+			}
+			p.advance()
+		}
+		return statements, p.expect(";")
+
+	case p.tok.kind == tokIdent && (p.tok.escaped || !verilogKeywords[p.tok.text]): // Instantiation, "and g1(...), g2(...);"
+		typ, module := p.tok.text, p.tok.escaped
+		p.advance()
+		if p.is("#") {
+			return nil, errorAt(p.tok.pos, "parameterized instances are not supported")
+		}
+		var statements []*verilogStmt
+		for {
+			stmt := &verilogStmt{pos: pos, kind: "instance", typ: typ, module: module}
+			if p.tok.kind == tokIdent {
+				stmt.name = p.tok.text
+				p.advance()
+			}
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			for !p.is(")") {
+				conn := verilogConn{pos: p.tok.pos}
+				if p.is(".") { // Named connection
+					p.advance()
+					var err error
+					if conn.port, err = p.ident("port name"); err != nil {
+						return nil, err
+					}
+					if err := p.expect("("); err != nil {
+						return nil, err
+					}
+					if !p.is(")") {
+						if conn.expr, err = p.parseExpr(); err != nil {
+							return nil, err
+						}
+					}
+					if err := p.expect(")"); err != nil {
+						return nil, err
+					}
+				} else if !p.is(",") {
+					var err error
+					if conn.expr, err = p.parseExpr(); err != nil {
+						return nil, err
+					}
+				}
+				stmt.conns = append(stmt.conns, conn)
+				if !p.is(",") {
+					break
+				}
+				p.advance()
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			statements = append(statements, stmt)
+			if !p.is(",") {
+				break
+			}
+			p.advance()
+			pos = p.tok.pos
+		}
+		return statements, p.expect(";")
+	}
+	return nil, errorAt(pos, "unsupported statement %v", p.tok)
+}
+
+// Keywords that cannot start an instantiation
+var verilogKeywords = map[string]bool{
+	"module": true, "endmodule": true, "input": true, "output": true, "inout": true, "wire": true,
+	"reg": true, "assign": true, "initial": true, "always": true, "begin": true, "end": true,
+	"parameter": true, "localparam": true, "integer": true, "generate": true, "function": true,
+}
+
+// ___________________________________________ Modules & Instances _____________________________
+
+// Verilog gate primitives: the operator joining the inputs and whether the result is inverted
+var gatePrimitives = map[string]struct {
+	op     string
+	invert bool
+}{
+	"and":  {"&", false},
+	"nand": {"&", true},
+	"or":   {"|", false},
+	"nor":  {"|", true},
+	"xor":  {"^", false},
+	"xnor": {"^", true},
+	"buf":  {"", false},
+	"not":  {"", true},
+}
+
+// elaborate adds the gates of a module instance to the netlist, collecting the errors of its statements.
+// stack holds the modules being elaborated, for instantiate to reject recursive instantiation.
+func (n *verilogNetlist) elaborate(def *verilogModuleDef, s *verilogScope, stack []string) {
+	stack = append(stack, def.name)
+
+	for _, stmt := range def.statements {
+		n.elaborateStatement(s, stmt, stack)
+	}
+}
+
+// elaborateStatement adds the wires and gates of a single statement to the netlist
+func (n *verilogNetlist) elaborateStatement(s *verilogScope, stmt *verilogStmt, stack []string) {
+	var err error
+	switch stmt.kind {
+	case "input", "output", "wire":
+		err = n.declare(s, stmt)
+	case "assign":
+		err = n.assign(s, stmt.lhs, stmt.rhs)
+	case "instance":
+		err = n.instantiate(s, stmt, stack)
+	}
+	if err != nil {
+		n.errs.add(errorAt(stmt.pos, "%v", err))
+	}
+}
+
+// instantiate adds a gate primitive ("and g1(o, a, b)") or a flattened module instance
+// ("adder a1(.a(x), .b(y), .s(z))" or "adder a1(x, y, z)") to the netlist
+func (n *verilogNetlist) instantiate(s *verilogScope, stmt *verilogStmt, stack []string) error {
+	if primitive, found := gatePrimitives[stmt.typ]; found && !stmt.module {
+		var terminals []*verilogExpr
+		for _, conn := range stmt.conns {
+			if conn.port != "" || conn.expr == nil {
+				return fmt.Errorf("gate %s takes positional connections only", stmt.typ)
+			}
+			terminals = append(terminals, conn.expr)
+		}
+		if len(terminals) < 2 {
+			return fmt.Errorf("gate %s needs an output and inputs", stmt.typ)
+		}
+		outs, ins := terminals[:1], terminals[1:]
+		if primitive.op == "" { // buf and not drive all terminals but the last one
+			outs, ins = terminals[:len(terminals)-1], terminals[len(terminals)-1:]
+		}
+		rhs := ins[0]
+		for _, in := range ins[1:] {
+			rhs = &verilogExpr{op: primitive.op, args: []*verilogExpr{rhs, in}}
+		}
+		if primitive.invert {
+			rhs = &verilogExpr{op: "~", args: []*verilogExpr{rhs}}
+		}
+		for _, out := range outs {
+			if err := n.assign(s, out, rhs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	def, found := n.modules[stmt.typ]
+	if !found {
+		return fmt.Errorf("unknown module %s", stmt.typ)
+	}
+	if stmt.name == "" {
+		return fmt.Errorf("instance of %s needs a name", stmt.typ)
+	}
+	if _, found := find(stack, def.name); found {
+		return fmt.Errorf("module %s instantiates itself: %s", def.name, strings.Join(append(stack, def.name), " -> "))
+	}
+	inner := newVerilogScope(s.prefix + stmt.name + ".")
 
-		// Declaration
-		if tokens[0] == "wire" || tokens[0] == "output" || tokens[0] == "input" {
-			if len(tokens) != 2 {
-				return nil, nil, nil, fmt.Errorf("unsupported statement: %s", l)
+	// Port declarations tell the port widths and directions before the connections are made
+	dirs := make(map[string]string)
+	for _, decl := range def.statements {
+		if decl.kind == "input" || decl.kind == "output" {
+			if err := n.declare(inner, &verilogStmt{kind: "wire", names: decl.names, rng: decl.rng}); err != nil {
+				return err
+			}
+			for _, port := range decl.names {
+				dirs[port] = decl.kind
+			}
+		}
+	}
+
+	// Named (.a(x)) or positional connections
+	bound := make(map[string]*verilogExpr)
+	for i, conn := range stmt.conns {
+		port := conn.port
+		if port == "" {
+			if i >= len(def.portOrder) {
+				return fmt.Errorf("too many connections to %s", stmt.typ)
+			}
+			port = def.portOrder[i]
+		} else if !def.ports[port] {
+			return fmt.Errorf("module %s has no port %s", stmt.typ, port)
+		}
+		if conn.expr != nil { // Not left unconnected
+			bound[port] = conn.expr
+		}
+	}
+
+	for _, port := range def.portOrder {
+		e, connected := bound[port]
+		if !connected {
+			continue
+		}
+		portBits := inner.wires[port]
+		switch dirs[port] {
+		case "input": // Lower the connected expression into the port wires
+			bits, err := n.exprBits(s, e, len(portBits))
+			if err != nil {
+				return err
 			}
-			typ, name := tokens[0], tokens[1]
-			if typ == "input" {
-				inputs = append(inputs, name)
-			} else if typ == "output" {
-				outputs = append(outputs, name)
+			for i, bit := range portBits {
+				if bits[i].op == "wire" {
+					portBits[i] = bits[i].name
+				} else {
+					n.lowerExpr(bit, bits[i])
+				}
 			}
-			circuit[name] = nil
-		} else if tokens[0] == "assign" { // Assignment
-			if tokens[2] != "=" {
-				return nil, nil, nil, fmt.Errorf("unsupported statement: %s", l)
+		case "output": // The port drives the connected wires
+			bits, err := n.lvalueBits(s, e)
+			if err != nil {
+				return err
 			}
-			lhs := tokens[1]
-			if strings.Contains(lhs, "[") || strings.Contains(lhs, ":") {
-				return nil, nil, nil, fmt.Errorf("unsupported statement: %s", l)
+			if len(bits) > len(portBits) {
+				return fmt.Errorf("port %s of %s is %d bits wide", port, stmt.typ, len(portBits))
 			}
-			// **must recheck this later...
-			rhs := strings.Join(tokens[3:], "")
-			// Further processing of rhs to handle operations...
-			circuit[lhs] = rhs // **check later...
+			copy(portBits, bits)
+		default:
+			return fmt.Errorf("port %s of %s has no direction", port, stmt.typ)
+		}
+	}
+	n.elaborate(def, inner, stack)
+	return nil
+}
+
+// ___________________________________________ Declarations & Bit-Blasting _____________________________
+
+// verilogNetlist collects the bit-blasted wires and gates of the flattened top module
+type verilogNetlist struct {
+	circuit map[string][]string // Map from wire name -> [gate, input wires...]
+	inputs  []string
+	outputs []string
+	buses   map[string][]string // Bus name -> bit wires of the top module, least significant bit first
+	modules map[string]*verilogModuleDef
+	errs    *errorList
+	emitted map[string]bool // Wires whose gates streamVerilog took out of circuit
+	idents  map[string]bool // Identifiers of the source, which intermediate wires must not be named after
+}
+
+func newVerilogNetlist(p *verilogParser) *verilogNetlist {
+	return &verilogNetlist{
+		circuit: make(map[string][]string),
+		buses:   make(map[string][]string),
+		modules: p.modules,
+		errs:    p.errs,
+		idents:  p.lex.idents,
+	}
+}
+
+// verilogScope maps the names used inside one module instance to wires of the flattened netlist
+type verilogScope struct {
+	prefix string              // Prepended to the wires of the instance: "" for the top module, "a1." for instance a1
+	wires  map[string][]string // Declared name -> bit wires, least significant bit first
+	ranges map[string][2]int   // Vector name -> declared [msb, lsb]
+}
+
+func newVerilogScope(prefix string) *verilogScope {
+	return &verilogScope{
+		prefix: prefix,
+		wires:  make(map[string][]string),
+		ranges: make(map[string][2]int),
+	}
+}
+
+// Function that returns the indexes from a to b of a [a:b] range, least significant (b) first
+func rangeIndexes(a, b int) []int {
+	step := 1
+	if a < b {
+		step = -1
+	}
+	var indexes []int
+	for i := b; i != a+step; i += step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// declare records the wires of a declaration statement, expanding vectors into one wire per bit.
+// Names declared before (ports connected by the instantiating module, "input x; wire x;") are kept.
+func (n *verilogNetlist) declare(s *verilogScope, stmt *verilogStmt) error {
+	for _, name := range stmt.names {
+		bits := []string{s.prefix + name}
+		if stmt.rng != nil {
+			if r, exists := s.ranges[name]; exists && r != *stmt.rng {
+				return fmt.Errorf("bus %s redeclared with a different range", name)
+			}
+			bits = nil
+			for i := range rangeIndexes(stmt.rng[0], stmt.rng[1]) { // Named by distance from the lsb, whatever the direction
+				bits = append(bits, fmt.Sprintf("%s%s_%d", s.prefix, name, i))
+			}
+		}
+		if existing, exists := s.wires[name]; exists {
+			if len(existing) != len(bits) {
+				return fmt.Errorf("%s redeclared with a different width", name)
+			}
+			bits = existing
 		} else {
-			return nil, nil, nil, fmt.Errorf("unsupported statement: %s", l)
+			s.wires[name] = bits
+		}
+		if stmt.rng != nil {
+			s.ranges[name] = *stmt.rng
+		}
+		if s.prefix != "" {
+			continue
+		}
+		if stmt.rng != nil {
+			n.buses[name] = bits
+		}
+		for _, bit := range bits {
+			if stmt.kind == "input" {
+				n.inputs = append(n.inputs, bit)
+			} else if stmt.kind == "output" {
+				n.outputs = append(n.outputs, bit)
+			}
+			if _, exists := n.circuit[bit]; !exists {
+				n.circuit[bit] = nil
+			}
+		}
+	}
+	return nil
+}
+
+// selectBits returns the bit wires referenced by x, x[i] or x[a:b], least significant bit first.
+// Undeclared names are implicit single bit wires.
+func (n *verilogNetlist) selectBits(s *verilogScope, e *verilogExpr) ([]string, error) {
+	bits, declared := s.wires[e.name]
+	if !declared {
+		bits = []string{s.prefix + e.name}
+	}
+	r, isBus := s.ranges[e.name]
+	if !e.sel {
+		return bits, nil
+	}
+	if !isBus {
+		return nil, fmt.Errorf("%s is not a vector", e.name)
+	}
+	lo, hi := r[1], r[0]
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	if e.hi < lo || e.hi > hi || e.lo < lo || e.lo > hi {
+		return nil, fmt.Errorf("select [%d:%d] out of range of %s[%d:%d]", e.hi, e.lo, e.name, r[0], r[1])
+	}
+	if e.hi != e.lo && (e.hi > e.lo) != (r[0] > r[1]) {
+		return nil, fmt.Errorf("select [%d:%d] reverses the direction of %s[%d:%d]", e.hi, e.lo, e.name, r[0], r[1])
+	}
+	var selected []string
+	for _, i := range rangeIndexes(e.hi, e.lo) {
+		position := i - r[1] // Distance from the declared least significant bit
+		if position < 0 {
+			position = -position
+		}
+		selected = append(selected, bits[position])
+	}
+	return selected, nil
+}
+
+// exprWidth returns the self-determined width of an expression
+func (n *verilogNetlist) exprWidth(s *verilogScope, e *verilogExpr) (int, error) {
+	switch e.op {
+	case "wire":
+		bits, err := n.selectBits(s, e)
+		return len(bits), err
+	case "const":
+		return len(e.name), nil
+	case "{}":
+		width := 0
+		for _, arg := range e.args {
+			w, err := n.exprWidth(s, arg)
+			if err != nil {
+				return 0, err
+			}
+			width += w
+		}
+		return width, nil
+	}
+	width := 0 // Bitwise operators are as wide as their widest operand
+	for _, arg := range e.args {
+		w, err := n.exprWidth(s, arg)
+		if err != nil {
+			return 0, err
+		}
+		if w > width {
+			width = w
+		}
+	}
+	return width, nil
+}
+
+// exprBits splits an expression into one single bit expression per bit, least significant bit first.
+// Operands of bitwise operators are zero-extended to the context width, as in Verilog.
+func (n *verilogNetlist) exprBits(s *verilogScope, e *verilogExpr, width int) ([]*verilogExpr, error) {
+	w, err := n.exprWidth(s, e)
+	if err != nil {
+		return nil, err
+	}
+	if w > width {
+		width = w
+	}
+
+	var bits []*verilogExpr
+	switch e.op {
+	case "wire":
+		names, _ := n.selectBits(s, e)
+		for _, name := range names {
+			bits = append(bits, &verilogExpr{op: "wire", name: name})
+		}
+	case "const":
+		for i := len(e.name) - 1; i >= 0; i-- {
+			bits = append(bits, &verilogExpr{op: "const", name: e.name[i : i+1]})
+		}
+	case "{}":
+		for i := len(e.args) - 1; i >= 0; i-- { // The last operand holds the least significant bits
+			argBits, err := n.exprBits(s, e.args[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			bits = append(bits, argBits...)
+		}
+	case "~":
+		argBits, err := n.exprBits(s, e.args[0], width)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range argBits {
+			bits = append(bits, &verilogExpr{op: "~", args: []*verilogExpr{b}})
+		}
+	default:
+		a, err := n.exprBits(s, e.args[0], width)
+		if err != nil {
+			return nil, err
+		}
+		b, err := n.exprBits(s, e.args[1], width)
+		if err != nil {
+			return nil, err
+		}
+		for i := range a {
+			bits = append(bits, &verilogExpr{op: e.op, args: []*verilogExpr{a[i], b[i]}})
+		}
+	}
+	for len(bits) < width {
+		bits = append(bits, &verilogExpr{op: "const", name: "0"})
+	}
+	return bits, nil
+}
+
+// Function that returns the bit wires an assignment target refers to, least significant bit first
+func (n *verilogNetlist) lvalueBits(s *verilogScope, e *verilogExpr) ([]string, error) {
+	switch e.op {
+	case "wire":
+		return n.selectBits(s, e)
+	case "{}":
+		var bits []string
+		for i := len(e.args) - 1; i >= 0; i-- {
+			argBits, err := n.lvalueBits(s, e.args[i])
+			if err != nil {
+				return nil, err
+			}
+			bits = append(bits, argBits...)
+		}
+		return bits, nil
+	}
+	return nil, fmt.Errorf("cannot assign to an expression")
+}
+
+// assign bit-blasts "lhs = rhs" and lowers every bit into gates
+func (n *verilogNetlist) assign(s *verilogScope, lhs, rhs *verilogExpr) error {
+	lhsBits, err := n.lvalueBits(s, lhs)
+	if err != nil {
+		return err
+	}
+	rhsBits, err := n.exprBits(s, rhs, len(lhsBits))
+	if err != nil {
+		return err
+	}
+	for i, bit := range lhsBits { // Extra right-hand side bits are truncated
+		if n.circuit[bit] != nil || n.emitted[bit] {
+			return fmt.Errorf("wire %s has multiple drivers", bit)
+		}
+		n.lowerExpr(bit, rhsBits[i])
+	}
+	return nil
+}
+
+// ___________________________________________ Assign Expressions _____________________________
+
+// verilogExpr is a node of an assign statement. Before bit-blasting a "wire" may be a whole bus or
+// a x[hi:lo] select and a "const" may hold several bits; afterwards both are single bits.
+type verilogExpr struct {
+	op     string // "wire", "const", "{}", "~", "&", "|", "^" or "~^"
+	name   string // Wire name, or the bits of a constant ("0", "1", "1010", ...) most significant first
+	sel    bool   // Whether a bit-select x[hi] (hi == lo) or part-select x[hi:lo] follows the wire name
+	hi, lo int
+	args   []*verilogExpr
+}
+
+// parseExpr parses an expression following the Verilog precedence: ~ binds tightest, then &, ^ and |
+func (p *verilogParser) parseExpr() (*verilogExpr, error) {
+	return p.parseBinary(0)
+}
+
+// Binary operators from the loosest to the tightest binding level
+var exprLevels = [][]string{{"|"}, {"^", "~^", "^~"}, {"&"}}
+
+func (p *verilogParser) parseBinary(level int) (*verilogExpr, error) {
+	if level == len(exprLevels) {
+		return p.parseUnary()
+	}
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.tok.text
+		if _, found := find(exprLevels[level], op); !found || p.tok.kind != tokOp {
+			return lhs, nil
+		}
+		p.advance()
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		if op == "^~" {
+			op = "~^"
+		}
+		lhs = &verilogExpr{op: op, args: []*verilogExpr{lhs, rhs}}
+	}
+}
+
+func (p *verilogParser) parseUnary() (*verilogExpr, error) {
+	tok := p.tok
+	switch {
+	case p.is("~"):
+		p.advance()
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &verilogExpr{op: "~", args: []*verilogExpr{arg}}, nil
+	case p.is("("):
+		p.advance()
+		expr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case p.is("{"):
+		p.advance()
+		return p.parseConcat()
+	case tok.kind == tokNumber:
+		p.advance()
+		expr, err := parseConst(tok.text)
+		if err != nil {
+			return nil, errorAt(tok.pos, "%v", err)
+		}
+		return expr, nil
+	case tok.kind == tokIdent && (tok.escaped || !verilogKeywords[tok.text]):
+		p.advance()
+		e := &verilogExpr{op: "wire", name: tok.text}
+		if !p.is("[") {
+			return e, nil
+		}
+		p.advance()
+		var err error
+		e.sel = true
+		if e.hi, err = p.number(); err != nil {
+			return nil, err
+		}
+		e.lo = e.hi
+		if p.is(":") {
+			p.advance()
+			if e.lo, err = p.number(); err != nil {
+				return nil, err
+			}
+		}
+		return e, p.expect("]")
+	}
+	return nil, errorAt(tok.pos, "unexpected %v in expression", tok)
+}
+
+// parseConcat parses the rest of a {a, b, ...} concatenation or a {n{a, ...}} replication
+func (p *verilogParser) parseConcat() (*verilogExpr, error) {
+	count := 1
+	replication := false
+	if p.tok.kind == tokNumber && !strings.Contains(p.tok.text, "'") {
+		// Either a replication count or a (rather pointless) unsized constant
+		tok := p.tok
+		p.advance()
+		if !p.is("{") {
+			return nil, errorAt(tok.pos, "unsized constant %s in concatenation", tok.text)
+		}
+		count, _ = strconv.Atoi(tok.text)
+		replication = true
+		p.advance()
+	}
+	var args []*verilogExpr
+	for {
+		arg, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.is(",") {
+			break
+		}
+		p.advance()
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	concat := &verilogExpr{op: "{}"}
+	for i := 0; i < count; i++ {
+		concat.args = append(concat.args, args...)
+	}
+	if replication {
+		return concat, p.expect("}")
+	}
+	return concat, nil
+}
+
+// Function that parses constants such as 1, 1'b0, 1'h1 or 32'd9001 into their bits.
+// Unsized constants are 32 bits wide.
+func parseConst(tok string) (*verilogExpr, error) {
+	width, base, digits := 32, 10, tok
+	if i := strings.IndexByte(tok, '\''); i >= 0 {
+		if i > 0 {
+			width, _ = strconv.Atoi(tok[:i])
+		}
+		digits = strings.TrimLeft(tok[i+1:], "sS") // Signedness makes no difference to the bits
+		if digits == "" {
+			return nil, fmt.Errorf("unsupported constant %s", tok)
+		}
+		base = map[byte]int{'b': 2, 'o': 8, 'd': 10, 'h': 16}[digits[0]|0x20] // |0x20 lower-cases the base
+		digits = digits[1:]
+	}
+	value, ok := new(big.Int).SetString(strings.ReplaceAll(digits, "_", ""), base)
+	if !ok || width == 0 || base == 0 {
+		return nil, fmt.Errorf("unsupported constant %s", tok)
+	}
+	bits := make([]byte, width)
+	for i := range bits {
+		bits[i] = '0' + byte(value.Bit(width-1-i))
+	}
+	return &verilogExpr{op: "const", name: string(bits)}, nil
+}
+
+// tempWire returns an unused name wire$1, wire$2, ... for an intermediate wire. It is neither a wire of the
+// netlist nor an identifier of the source, also with an instance prefix taken off: a1.o$1 may be o$1 of instance a1.
+func (n *verilogNetlist) tempWire(wire string) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s$%d", wire, i)
+		if _, exists := n.circuit[name]; exists || n.emitted[name] {
+			continue
+		}
+		clash := n.idents[name]
+		for rest := name; !clash && strings.Contains(rest, "."); {
+			rest = rest[strings.Index(rest, ".")+1:]
+			clash = n.idents[rest]
+		}
+		if !clash {
+			n.circuit[name] = nil // Taken, before the gates of the sub-expression pick their names
+			return name
+		}
+	}
+}
+
+// lowerExpr lowers an expression into gates driving wire, using the gate names labelTruthTable knows.
+// Nested sub-expressions get intermediate wires from tempWire.
+func (n *verilogNetlist) lowerExpr(wire string, expr *verilogExpr) {
+	circuit := n.circuit
+	var lower func(out string, e *verilogExpr)
+
+	// operand returns a wire holding the value of e, lowering it into a fresh wire unless it is one already
+	operand := func(e *verilogExpr) string {
+		if e.op == "wire" {
+			return e.name
+		}
+		name := n.tempWire(wire)
+		lower(name, e)
+		return name
+	}
+
+	lower = func(out string, e *verilogExpr) {
+		switch e.op {
+		case "wire":
+			circuit[out] = []string{"buf", e.name}
+		case "const":
+			circuit[out] = []string{"const_" + e.name}
+		case "~":
+			a := e.args[0]
+			switch a.op {
+			case "const": // Fold ~1'b0 and ~1'b1
+				circuit[out] = []string{"const_" + map[string]string{"0": "1", "1": "0"}[a.name]}
+			case "~": // Double negation
+				lower(out, a.args[0])
+			case "&":
+				circuit[out] = []string{"nand", operand(a.args[0]), operand(a.args[1])}
+			case "|":
+				circuit[out] = []string{"nor", operand(a.args[0]), operand(a.args[1])}
+			case "^":
+				circuit[out] = []string{"xnor", operand(a.args[0]), operand(a.args[1])}
+			case "~^":
+				circuit[out] = []string{"xor", operand(a.args[0]), operand(a.args[1])}
+			default:
+				circuit[out] = []string{"not", operand(a)}
+			}
+		case "&", "|":
+			gate := map[string]string{"&": "and", "|": "or"}[e.op]
+			a, b := e.args[0], e.args[1]
+			if b.op == "~" { // a & ~b, a | ~b
+				circuit[out] = []string{gate + "not", operand(a), operand(b.args[0])}
+			} else if a.op == "~" { // ~a & b, ~a | b
+				circuit[out] = []string{gate + "not", operand(b), operand(a.args[0])}
+			} else {
+				circuit[out] = []string{gate, operand(a), operand(b)}
+			}
+		case "^":
+			circuit[out] = []string{"xor", operand(e.args[0]), operand(e.args[1])}
+		case "~^":
+			circuit[out] = []string{"xnor", operand(e.args[0]), operand(e.args[1])}
 		}
 	}
 
-	return circuit, inputs, outputs, nil
+	lower(wire, expr)
 }
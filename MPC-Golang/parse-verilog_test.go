@@ -0,0 +1,284 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Function that writes source to a file in a temporary directory and returns its name
+func writeTestFile(t *testing.T, name, source string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestParseVerilogRangeDirections(t *testing.T) {
+	filename := writeTestFile(t, "ranges.v", `
+module ranges(a, b, o, l);
+  input [0:3] a;
+  input [8:5] b;
+  output [0:3] o;
+  output l;
+  assign o = a ^ b;
+  assign l = a[3];
+endmodule
+`)
+	circuit, inputs, outputs, buses, err := parseVerilogBuses(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bus, want := range map[string][]string{
+		"a": {"a_0", "a_1", "a_2", "a_3"},
+		"b": {"b_0", "b_1", "b_2", "b_3"},
+		"o": {"o_0", "o_1", "o_2", "o_3"},
+	} {
+		if !reflect.DeepEqual(buses[bus], want) {
+			t.Errorf("bus %s = %v, want %v", bus, buses[bus], want)
+		}
+	}
+
+	words := map[string]*big.Int{"a": big.NewInt(5), "b": big.NewInt(3)}
+	got, err := simulateCircuitWords(circuit, inputs, outputs, words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["o"].Int64() != 5^3 {
+		t.Errorf("o = %v, want %d", got["o"], 5^3)
+	}
+	if got["l"].Int64() != 1 { // a[3] is the lsb of a[0:3]
+		t.Errorf("l = %v, want 1", got["l"])
+	}
+
+	for _, bad := range []string{
+		"module m(a, o); input a; wire [3:0] a; output o; assign o = a[3]; endmodule",
+		"module m(a, o); input [1:0] a; wire a; output o; assign o = a; endmodule",
+	} {
+		if _, _, _, err := parseVerilog(writeTestFile(t, "redeclared.v", bad)); err == nil || !strings.Contains(err.Error(), "different width") {
+			t.Errorf("%q: error %v, want a redeclaration with a different width", bad, err)
+		}
+	}
+}
+
+func TestStreamVerilogOrder(t *testing.T) {
+	reversed := writeTestFile(t, "reversed.v", `
+module reversed(a, b, o);
+  input [1:0] a;
+  input b;
+  output o;
+  assign o = t2 | u;
+  assign t2 = t1 & a[1];
+  assign t1 = ~(a[0] ^ b);
+endmodule
+`)
+	for _, filename := range []string{"out.v", reversed} {
+		circuit, inputs, outputs, err := parseVerilog(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		streamed := make(map[string][]string)
+		streamInputs, streamOutputs, err := streamVerilog(f, filename, "", func(wire string, gate []string) error {
+			for _, in := range gate[1:] {
+				_, isInput := find(inputs, in)
+				if _, emitted := streamed[in]; !isInput && !emitted && len(circuit[in]) > 0 {
+					t.Errorf("%s: %s emitted before its input %s", filename, wire, in)
+				}
+			}
+			streamed[wire] = gate
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(streamInputs, inputs) || !reflect.DeepEqual(streamOutputs, outputs) {
+			t.Errorf("%s: streamed ports %v %v, want %v %v", filename, streamInputs, streamOutputs, inputs, outputs)
+		}
+		for wire, gate := range circuit {
+			if len(gate) > 0 && !reflect.DeepEqual(streamed[wire], gate) {
+				t.Errorf("%s: streamed %s = %v, want %v", filename, wire, streamed[wire], gate)
+			}
+		}
+	}
+
+	// parseVerilog takes the last module nobody instantiates, which a stream only knows at the end
+	hierarchical := `
+module ha(a, b, s, c); input a, b; output s, c; assign s = a ^ b; assign c = a & b; endmodule
+module top(x, y, s, c); input x, y; output s, c; ha h(x, y, s, c); endmodule
+`
+	circuit, _, _, err := parseVerilog(writeTestFile(t, "hierarchical.v", hierarchical))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := make(map[string][]string)
+	collect := func(wire string, gate []string) error { streamed[wire] = gate; return nil }
+	if _, _, err := streamVerilog(strings.NewReader(hierarchical), "hierarchical.v", "top", collect); err != nil {
+		t.Fatal(err)
+	}
+	for wire, gate := range circuit {
+		if len(gate) > 0 && !reflect.DeepEqual(streamed[wire], gate) {
+			t.Errorf("hierarchical.v: streamed %s = %v, want %v", wire, streamed[wire], gate)
+		}
+	}
+	if _, _, err := streamVerilog(strings.NewReader(hierarchical), "hierarchical.v", "", collect); err == nil {
+		t.Error("no error for an unnamed top module in a file with two modules")
+	}
+
+	cycle := `module cycle(a, o); input a; output o; assign o = p & a; assign p = ~o; endmodule`
+	emitted := 0
+	_, _, err = streamVerilog(strings.NewReader(cycle), "cycle.v", "", func(string, []string) error { emitted++; return nil })
+	if err == nil || emitted != 0 {
+		t.Errorf("cycle: emitted %d gates, error %v", emitted, err)
+	}
+}
+
+func TestParseVerilogLowering(t *testing.T) {
+	filename := writeTestFile(t, "lowering.v", `
+module lowering(a, b, c, nor, xnor, andnot, o, prec, o$1);
+  input a, b, c;
+  output nor, xnor, andnot, o, prec, o$1;
+  assign nor = ~(a | b);
+  assign xnor = ~(a ^ b);
+  assign andnot = a & ~b;
+  assign o = (a | b) & ~(b ^ c); // Its intermediate wire is not o$1
+  assign prec = a | b & c ^ a;
+  assign o$1 = ~c;
+endmodule
+`)
+	circuit, inputs, outputs, err := parseVerilog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for wire, want := range map[string][]string{
+		"nor":    {"nor", "a", "b"},
+		"xnor":   {"xnor", "a", "b"},
+		"andnot": {"andnot", "a", "b"},
+		"o$1":    {"not", "c"},
+	} {
+		if !reflect.DeepEqual(circuit[wire], want) {
+			t.Errorf("%s = %v, want %v", wire, circuit[wire], want)
+		}
+	}
+	if gate := circuit["o"]; len(gate) != 3 || gate[0] != "andnot" || !reflect.DeepEqual(circuit[gate[1]], []string{"or", "a", "b"}) {
+		t.Errorf("o = %v", gate)
+	}
+	for v := 0; v < 8; v++ {
+		a, b, c := v&1 == 1, v&2 == 2, v&4 == 4
+		got, err := simulateCircuit(circuit, inputs, outputs, map[string]bool{"a": a, "b": b, "c": c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := a || (b && c) != a; got["prec"] != want { // & binds tighter than ^, ^ tighter than |
+			t.Errorf("prec is %v for a=%v b=%v c=%v, want %v", got["prec"], a, b, c, want)
+		}
+		if want := (a || b) && b == c; got["o"] != want {
+			t.Errorf("o is %v for a=%v b=%v c=%v, want %v", got["o"], a, b, c, want)
+		}
+	}
+}
+
+func TestParseVerilogInstances(t *testing.T) {
+	filename := writeTestFile(t, "instances.v", `
+module ha(a, b, s, c);
+  input a, b;
+  output s, c;
+  xor g1(s, a, b);
+  and (c, a, b);
+endmodule
+
+module top(x, y, z, sum, carry, n, m);
+  input [1:0] x;
+  input y, z;
+  output [1:0] sum;
+  output carry, n, m;
+  wire c0, c1;
+  ha h0(.a(x[0]), .b(y), .s(sum[0]), .c(c0));
+  ha h1(x[1], c0, sum[1], c1);
+  or (carry, c1, z);
+  nand (n, x[0], x[1], y);
+  not (m, z);
+endmodule
+`)
+	circuit, inputs, outputs, err := parseVerilog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gate := circuit["sum_0"]; !reflect.DeepEqual(gate, []string{"xor", "x_0", "y"}) { // Ports are the connected wires
+		t.Errorf("sum_0 = %v, want an xor of x_0 and y", gate)
+	}
+	for x := int64(0); x < 4; x++ {
+		for y := int64(0); y < 2; y++ {
+			for z := int64(0); z < 2; z++ {
+				words := map[string]*big.Int{"x": big.NewInt(x), "y": big.NewInt(y), "z": big.NewInt(z)}
+				got, err := simulateCircuitWords(circuit, inputs, outputs, words)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got["sum"].Int64() != (x+y)&3 || got["carry"].Int64() != (x+y)>>2|z || got["n"].Int64() != 1^(x>>1&x&y) ||
+					got["m"].Int64() != 1^z {
+					t.Errorf("x=%d y=%d z=%d gives %v", x, y, z, got)
+				}
+			}
+		}
+	}
+
+	inv := "module inv(a, o); input a; output o; assign o = ~a; endmodule\n"
+	for _, bad := range []string{
+		"module top(a, o); input a; output o; adder i(a, o); endmodule",                   // Unknown module
+		inv + "module top(a, o); input a; output [1:0] o; inv i(.a(a), .o(o)); endmodule", // Output port narrower than its connection
+		inv + "module top(a, o); input a; output o; inv i(.a(a), .q(o)); endmodule",       // No such port
+		inv + "module top(a, o); input a; output o; inv i(a, o, a); endmodule",            // Too many connections
+		inv + "module top(a, o); input a; output o; inv (a, o); endmodule",                // Unnamed instance
+		"module top(a, o); input a; output o; and (o); endmodule",                         // Gate without inputs
+		"module top(a, o); input a; output o; and (.y(o), .a(a)); endmodule",              // Named gate terminals
+		"module m(a, o); input a; output o; n i(a, o); endmodule\n" + // Recursion through another module
+			"module n(a, o); input a; output o; m i(a, o); endmodule\n" +
+			"module top(a, o); input a; output o; m i(a, o); endmodule",
+	} {
+		if _, _, _, err := parseVerilog(writeTestFile(t, "bad.v", bad)); err == nil {
+			t.Errorf("no error parsing %q", bad)
+		}
+	}
+	_, _, _, err = parseVerilog(writeTestFile(t, "recursive.v", "module top(a, o); input a; output o; top t(a, o); endmodule"))
+	if err == nil || !strings.Contains(err.Error(), "instantiates itself") {
+		t.Errorf("error %v for a module instantiating itself", err)
+	}
+}
+
+func TestVerilogDiagnostics(t *testing.T) {
+	for _, test := range []struct {
+		source string
+		want   []string
+	}{
+		{ // Several errors in one pass, with the lines after a multi-line comment still counted
+			source: "module m(a, o);\n  input a;\n  /* a comment\n     over two lines */ output o;\n  assign o = a @ a;\n" +
+				"  wire [3:x] w;\n  assign o = ;\nendmodule\n",
+			want: []string{
+				"diag.v:5:16: unexpected character '@'",
+				"diag.v:5:18: expected ';', found 'a'",
+				"diag.v:6:11: expected a number, found 'x'",
+				"diag.v:7:14: unexpected ';' in expression",
+			},
+		},
+		{
+			source: "module m(a);\n input a; /* open\n",
+			want:   []string{"diag.v:2:11: unterminated /* comment", "diag.v:3:1: missing 'endmodule' of module m"},
+		},
+	} {
+		_, _, err := streamVerilog(strings.NewReader(test.source), "diag.v", "", func(string, []string) error { return nil })
+		if err == nil || err.Error() != strings.Join(test.want, "\n") {
+			t.Errorf("errors\n%v\nwant\n%s", err, strings.Join(test.want, "\n"))
+		}
+	}
+}
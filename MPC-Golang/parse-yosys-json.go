@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ___________________________________________ Yosys JSON Netlist Parser _____________________________
+// Reads the netlist written by Yosys' write_json after the design is flattened and mapped to internal
+// gate cells (e.g. synth -flatten; abc -g AND,NAND,OR,NOR,XOR,XNOR,ANDNOT,ORNOT,MUX). Bit i of a bus x
+// becomes the wire x_i as in parseVerilogBuses, single bit nets keep their name and hidden nets are named
+// _<net number>_.
+
+type yosysModule struct {
+	Attributes map[string]interface{} `json:"attributes"`
+	Ports      json.RawMessage        `json:"ports"` // Decoded in order, see orderedKeys
+	Cells      map[string]struct {
+		Type        string                `json:"type"`
+		Connections map[string][]yosysBit `json:"connections"`
+	} `json:"cells"`
+	Netnames map[string]struct {
+		HideName int        `json:"hide_name"`
+		Bits     []yosysBit `json:"bits"`
+	} `json:"netnames"`
+}
+
+type yosysPort struct {
+	Direction string     `json:"direction"`
+	Bits      []yosysBit `json:"bits"`
+}
+
+// yosysBit is a net number, or one of the constants "0", "1", "x" and "z"
+type yosysBit struct {
+	net   int
+	value string
+}
+
+func (b *yosysBit) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &b.net); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &b.value)
+}
+
+// Gates of the Yosys internal cell library that map directly onto gates of labelTruthTable
+var yosysCellGates = map[string]string{
+	"$_BUF_":    "buf",
+	"$_NOT_":    "not",
+	"$_AND_":    "and",
+	"$_OR_":     "or",
+	"$_XOR_":    "xor",
+	"$_NAND_":   "nand",
+	"$_NOR_":    "nor",
+	"$_XNOR_":   "xnor",
+	"$_ANDNOT_": "andnot", // A & ~B
+	"$_ORNOT_":  "ornot",  // A | ~B
+}
+
+// Function that returns the keys of a JSON object in the order they appear in
+func orderedKeys(object json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(object))
+	if _, err := dec.Token(); err != nil { // Opening brace
+		return nil, err
+	}
+	var keys []string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func parseYosysJSON(filename string) (map[string][]string, []string, []string, error) {
+	circuit, inputs, outputs, _, err := parseYosysJSONBuses(filename)
+	return circuit, inputs, outputs, err
+}
+
+// parseYosysJSONBuses parses like parseYosysJSON and also returns the bus -> bit wires mapping
+// (least significant bit first) of every multi-bit port and visible net of the top module.
+func parseYosysJSONBuses(filename string) (map[string][]string, []string, []string, map[string][]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var netlist struct {
+		Modules map[string]yosysModule `json:"modules"`
+	}
+	if err := json.Unmarshal(data, &netlist); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// Pick the module marked as top, or the only module
+	var top string
+	for name, module := range netlist.Modules {
+		if value, ok := module.Attributes["top"].(string); ok && strings.Trim(value, "0") != "" {
+			top = name
+		}
+	}
+	if top == "" && len(netlist.Modules) == 1 {
+		for name := range netlist.Modules {
+			top = name
+		}
+	}
+	module, found := netlist.Modules[top]
+	if !found {
+		return nil, nil, nil, nil, fmt.Errorf("cannot tell the top module of %s", filename)
+	}
+
+	circuit := make(map[string][]string)
+	buses := make(map[string][]string)
+	var inputs, outputs []string
+	names := make(map[int]string) // Net number -> wire name
+
+	bitName := func(name string, i, width int) string { // Bits are listed lsb first whatever the declared range
+		if width == 1 {
+			return name
+		}
+		return fmt.Sprintf("%s_%d", name, i)
+	}
+	constWire := func(value string) (string, error) {
+		if value != "0" && value != "1" {
+			return "", fmt.Errorf("unsupported constant %s", value)
+		}
+		wire := "_const_" + value + "_"
+		circuit[wire] = []string{"const_" + value}
+		return wire, nil
+	}
+	wireOf := func(bit yosysBit) (string, error) {
+		if bit.value != "" {
+			return constWire(bit.value)
+		}
+		if _, named := names[bit.net]; !named { // A net without netnames entry
+			names[bit.net] = "_" + strconv.Itoa(bit.net) + "_"
+		}
+		return names[bit.net], nil
+	}
+
+	// Ports first, so that their nets are named after them
+	portNames, err := orderedKeys(module.Ports)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var ports map[string]yosysPort
+	if err := json.Unmarshal(module.Ports, &ports); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	// Outputs sharing their net with an input, another output or a constant are copied with buf gates
+	type outputCopy struct {
+		wire string
+		bit  yosysBit
+	}
+	var copies []outputCopy
+	for _, name := range portNames {
+		port := ports[name]
+		var bits []string
+		for i, bit := range port.Bits {
+			wire := bitName(name, i, len(port.Bits))
+			bits = append(bits, wire)
+			switch port.Direction {
+			case "input":
+				if bit.value != "" {
+					return nil, nil, nil, nil, fmt.Errorf("input %s is tied to a constant", wire)
+				}
+				names[bit.net] = wire
+				circuit[wire] = nil
+				inputs = append(inputs, wire)
+			case "output":
+				if _, named := names[bit.net]; named || bit.value != "" {
+					copies = append(copies, outputCopy{wire, bit})
+				} else {
+					names[bit.net] = wire
+				}
+				outputs = append(outputs, wire)
+			default:
+				return nil, nil, nil, nil, fmt.Errorf("unsupported %s port %s", port.Direction, name)
+			}
+		}
+		if len(bits) > 1 {
+			buses[name] = bits
+		}
+	}
+
+	// Then visible nets, then the hidden ones
+	netNames := make([]string, 0, len(module.Netnames))
+	for name := range module.Netnames {
+		netNames = append(netNames, name)
+	}
+	sort.Strings(netNames)
+	for _, hidden := range []int{0, 1} {
+		for _, name := range netNames {
+			net := module.Netnames[name]
+			if (net.HideName != 0) != (hidden == 1) {
+				continue
+			}
+			var bits []string
+			for i, bit := range net.Bits {
+				if bit.value != "" {
+					continue
+				}
+				if _, named := names[bit.net]; !named {
+					if hidden == 1 {
+						names[bit.net] = "_" + strconv.Itoa(bit.net) + "_"
+					} else {
+						names[bit.net] = bitName(name, i, len(net.Bits))
+					}
+				}
+				bits = append(bits, names[bit.net])
+			}
+			if hidden == 0 && len(net.Bits) > 1 && buses[name] == nil {
+				buses[name] = bits
+			}
+		}
+	}
+
+	for cellName, cell := range module.Cells {
+		y := cell.Connections["Y"]
+		if len(y) != 1 || y[0].value != "" {
+			return nil, nil, nil, nil, fmt.Errorf("cell %s: unsupported cell type %s", cellName, cell.Type)
+		}
+		out, _ := wireOf(y[0])
+		if circuit[out] != nil {
+			return nil, nil, nil, nil, fmt.Errorf("cell %s: wire %s has multiple drivers", cellName, out)
+		}
+
+		var ports []string
+		switch cell.Type {
+		case "$_BUF_", "$_NOT_":
+			ports = []string{"A"}
+		case "$_MUX_", "$_NMUX_":
+			ports = []string{"A", "B", "S"}
+		default:
+			if _, found := yosysCellGates[cell.Type]; !found {
+				return nil, nil, nil, nil, fmt.Errorf("cell %s: unsupported cell type %s", cellName, cell.Type)
+			}
+			ports = []string{"A", "B"}
+		}
+		var ins []string
+		for _, port := range ports {
+			bits := cell.Connections[port]
+			if len(bits) != 1 {
+				return nil, nil, nil, nil, fmt.Errorf("cell %s: port %s is not a single bit", cellName, port)
+			}
+			wire, err := wireOf(bits[0])
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("cell %s: %v", cellName, err)
+			}
+			ins = append(ins, wire)
+		}
+
+		if cell.Type == "$_MUX_" || cell.Type == "$_NMUX_" { // S ? B : A == A ^ (S & (A ^ B))
+			diff := freshWire(circuit, out)
+			circuit[diff] = []string{"xor", ins[0], ins[1]}
+			sel := freshWire(circuit, out)
+			circuit[sel] = []string{"and", ins[2], diff}
+			circuit[out] = []string{map[string]string{"$_MUX_": "xor", "$_NMUX_": "xnor"}[cell.Type], ins[0], sel}
+			continue
+		}
+		circuit[out] = append([]string{yosysCellGates[cell.Type]}, ins...)
+	}
+
+	for _, c := range copies {
+		if circuit[c.wire] != nil {
+			return nil, nil, nil, nil, fmt.Errorf("wire %s has multiple drivers", c.wire)
+		}
+		in, err := wireOf(c.bit)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		circuit[c.wire] = []string{"buf", in}
+	}
+
+	return circuit, inputs, outputs, buses, nil
+}
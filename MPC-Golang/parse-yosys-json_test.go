@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// y = s ? b : a through a mux and an inverted mux, with outputs sharing the net of an input and a constant
+const yosysSelect = `{
+  "modules": {
+    "select": {
+      "attributes": {"top": "00000000000000000000000000000001"},
+      "ports": {
+        "a": {"direction": "input", "bits": [2, 3]},
+        "b": {"direction": "input", "bits": [4, 5]},
+        "s": {"direction": "input", "bits": [6]},
+        "y": {"direction": "output", "bits": [7, 8]},
+        "c": {"direction": "output", "bits": [2]},
+        "k": {"direction": "output", "bits": ["1"]}
+      },
+      "cells": {
+        "$abc$1": {"type": "$_MUX_", "connections": {"A": [2], "B": [4], "S": [6], "Y": [7]}},
+        "$abc$2": {"type": "$_NMUX_", "connections": {"A": [3], "B": [5], "S": [6], "Y": [9]}},
+        "$abc$3": {"type": "$_NOT_", "connections": {"A": [9], "Y": [8]}}
+      },
+      "netnames": {
+        "$abc$n9": {"hide_name": 1, "bits": [9]},
+        "y": {"hide_name": 0, "bits": [7, 8]}
+      }
+    }
+  }
+}`
+
+func TestParseYosysJSON(t *testing.T) {
+	circuit, inputs, outputs, buses, err := parseYosysJSONBuses(writeTestFile(t, "select.json", yosysSelect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a_0", "a_1", "b_0", "b_1", "s"}; !reflect.DeepEqual(inputs, want) {
+		t.Errorf("inputs %v, want %v", inputs, want)
+	}
+	if want := []string{"y_0", "y_1", "c", "k"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs %v, want %v", outputs, want)
+	}
+	if want := []string{"y_0", "y_1"}; !reflect.DeepEqual(buses["y"], want) {
+		t.Errorf("bus y = %v, want %v", buses["y"], want)
+	}
+	if err := ValidateCircuit(circuit, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	for a := int64(0); a < 4; a++ {
+		for b := int64(0); b < 4; b++ {
+			for s := int64(0); s < 2; s++ {
+				words := map[string]*big.Int{"a": big.NewInt(a), "b": big.NewInt(b), "s": big.NewInt(s)}
+				got, err := simulateCircuitWords(circuit, inputs, outputs, words)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := a
+				if s == 1 {
+					want = b
+				}
+				if got["y"].Int64() != want || got["c"].Int64() != a&1 || got["k"].Int64() != 1 {
+					t.Errorf("a=%d b=%d s=%d gives y=%v c=%v k=%v", a, b, s, got["y"], got["c"], got["k"])
+				}
+			}
+		}
+	}
+
+	flipFlop := strings.Replace(yosysSelect, `"$_NOT_"`, `"$_DFF_P_"`, 1)
+	if _, _, _, err := parseYosysJSON(writeTestFile(t, "dff.json", flipFlop)); err == nil {
+		t.Error("no error for a flip-flop cell")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+)
+
+// ___________________________________________ AND Depth _____________________________
+// Round-based protocols such as GMW need a round of communication per layer of AND gates, so the number of
+// sequential non-free gates (the AND depth) matters more there than the gate count.
+
+// Function that returns the AND depth of every wire: the most gates that are not free under free-XOR
+// (see isAffineTable) on a path from an input to the wire
+func andDepths(circuit map[string][]string, inputs, outputs []string) map[string]int {
+	depths := make(map[string]int)
+	for _, wire := range topoOrder(circuit, inputs, outputs) {
+		gate := circuit[wire]
+		if _, isInput := find(inputs, wire); isInput || len(gate) == 0 {
+			depths[wire] = 0
+			continue
+		}
+		depth := 0
+		for _, in := range gate[1:] {
+			depth = max(depth, depths[in])
+		}
+		if table, err := gateTruthTable(gate[0], len(gate)-1); err != nil || !isAffineTable(table) {
+			depth++
+		}
+		depths[wire] = depth
+	}
+	return depths
+}
+
+// andDepth returns the AND depth of a circuit, the largest AND depth of its outputs
+func andDepth(circuit map[string][]string, inputs, outputs []string) int {
+	depths := andDepths(circuit, inputs, outputs)
+	depth := 0
+	for _, output := range outputs {
+		depth = max(depth, depths[output])
+	}
+	return depth
+}
+
+// Gates whose chains can be regrouped freely, (a & b) & c == a & (b & c)
+var associativeGates = map[string]bool{"and": true, "or": true, "xor": true}
+
+// rebalanceCircuit rebuilds chains of the same associative gate, such as the long AND chain of out.v, as trees
+// of least AND depth. A chain is a gate with the gates of the same type it reads, as long as nothing else reads
+// them. Its leaves are combined shallowest first, which gives the least depth for the depths of the leaves.
+// Gates the outputs do not depend on are left out.
+func rebalanceCircuit(circuit map[string][]string, inputs, outputs []string) (map[string][]string, optimizeReport) {
+	isOutput := make(map[string]bool)
+	for _, output := range outputs {
+		isOutput[output] = true
+	}
+	readers := make(map[string][]string) // Wire -> gates reading it
+	for wire, gate := range circuit {
+		for _, in := range gate[min(1, len(gate)):] {
+			readers[in] = append(readers[in], wire)
+		}
+	}
+	// inChain tells whether a gate is part of the chain of the gate reading it
+	inChain := func(wire string) bool {
+		gate := circuit[wire]
+		return len(gate) == 3 && associativeGates[gate[0]] && !isOutput[wire] && len(readers[wire]) == 1 &&
+			circuit[readers[wire][0]][0] == gate[0]
+	}
+
+	type leaf struct {
+		wire         string
+		depth, level int // AND depth, and the number of gates of any kind as a tie-breaker
+	}
+	balanced := make(map[string][]string)
+	depths := make(map[string]leaf)
+	names := make(map[string][]string, len(circuit)) // Wire names in use, for the new gates of the trees
+	for wire := range circuit {
+		names[wire] = nil
+	}
+	// combine adds the gate reading two leaves, driving wire or a fresh wire when wire is ""
+	combine := func(op string, cost int, a, b leaf, wire string) leaf {
+		if wire == "" {
+			wire = freshWire(names, a.wire)
+			names[wire] = nil
+		}
+		balanced[wire] = []string{op, a.wire, b.wire}
+		return leaf{wire, max(a.depth, b.depth) + cost, max(a.level, b.level) + 1}
+	}
+	for _, wire := range topoOrder(circuit, inputs, outputs) {
+		gate := circuit[wire]
+		if _, isInput := find(inputs, wire); isInput || len(gate) == 0 {
+			balanced[wire] = gate
+			depths[wire] = leaf{wire, 0, 0}
+			continue
+		}
+		if inChain(wire) { // Rebuilt with the gate at the end of its chain
+			continue
+		}
+
+		// Leaves of the chain ending at wire, or the inputs of any other gate
+		var leaves []leaf
+		var collect func(w string)
+		collect = func(w string) {
+			for _, in := range circuit[w][1:] {
+				if inChain(in) && circuit[in][0] == gate[0] {
+					collect(in)
+				} else {
+					leaves = append(leaves, depths[in])
+				}
+			}
+		}
+		collect(wire)
+
+		cost := 0
+		if table, err := gateTruthTable(gate[0], len(gate)-1); err != nil || !isAffineTable(table) {
+			cost = 1
+		}
+		if !associativeGates[gate[0]] || len(gate) != 3 {
+			balanced[wire] = gate
+			deepest := leaf{wire, 0, 0}
+			for _, in := range leaves {
+				deepest.depth, deepest.level = max(deepest.depth, in.depth), max(deepest.level, in.level)
+			}
+			depths[wire] = leaf{wire, deepest.depth + cost, deepest.level + 1}
+			continue
+		}
+
+		// Combine the two shallowest leaves until one is left
+		for len(leaves) > 2 {
+			sort.SliceStable(leaves, func(i, j int) bool {
+				if leaves[i].depth != leaves[j].depth {
+					return leaves[i].depth < leaves[j].depth
+				}
+				return leaves[i].level < leaves[j].level
+			})
+			combined := combine(gate[0], cost, leaves[0], leaves[1], "")
+			leaves = append([]leaf{combined}, leaves[2:]...)
+		}
+		depths[wire] = combine(gate[0], cost, leaves[0], leaves[1], wire)
+	}
+	return balanced, optimizeReport{"AND depth", andDepth(circuit, inputs, outputs), andDepth(balanced, inputs, outputs)}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRebalanceCircuit(t *testing.T) {
+	circuit, inputs, outputs, err := parseVerilog("out.v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebalanced, report := rebalanceCircuit(circuit, inputs, outputs)
+	if report.after > report.before || report.after != andDepth(rebalanced, inputs, outputs) {
+		t.Errorf("out.v: %v for an AND depth of %d", report, andDepth(rebalanced, inputs, outputs))
+	}
+	assertEquivalent(t, circuit, inputs, outputs, rebalanced, inputs, outputs)
+
+	// An AND chain over 9 inputs becomes a tree of depth 4
+	b := NewCircuitBuilder()
+	x := b.InputWord("x", 9)
+	chain := x[0]
+	for _, w := range x[1:] {
+		chain = b.And(chain, w)
+	}
+	b.Output("out", chain)
+	circuit, inputs, outputs = b.Build()
+	rebalanced, report = rebalanceCircuit(circuit, inputs, outputs)
+	if report.before != 8 || report.after != 4 {
+		t.Errorf("AND chain of 9 inputs: %v, want 8 -> 4", report)
+	}
+	assertEquivalent(t, circuit, inputs, outputs, rebalanced, inputs, outputs)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+)
+
+// ___________________________________________ Plaintext Simulator _____________________________
+// Evaluates circuits in the clear with the gate semantics of labelTruthTable, as the reference for what a
+// garbled evaluation must decode to.
+
+// simulateCircuit evaluates a circuit on the given input values and returns the value of every wire:
+// the inputs, the outputs and every gate, including the gates no output depends on
+func simulateCircuit(circuit map[string][]string, inputs, outputs []string, values map[string]bool) (map[string]bool, error) {
+	evaluated := make(map[string]bool, len(circuit))
+	for _, input := range inputs {
+		value, exists := values[input]
+		if !exists {
+			return nil, fmt.Errorf("no value for input %s", input)
+		}
+		evaluated[input] = value
+	}
+
+	gates, err := simulationOrder(circuit, inputs, outputs)
+	if err != nil {
+		return nil, err
+	}
+	for _, wire := range gates {
+		gate := circuit[wire]
+		table, err := gateTruthTable(gate[0], len(gate)-1)
+		if err != nil {
+			return nil, fmt.Errorf("wire %s: %v", wire, err)
+		}
+		row := 0 // The first input is the most significant bit of the row index
+		for _, in := range gate[1:] {
+			value, known := evaluated[in]
+			if !known {
+				return nil, fmt.Errorf("wire %s reads %s, which is undriven or on a combinational cycle", wire, in)
+			}
+			row *= 2
+			if value {
+				row++
+			}
+		}
+		evaluated[wire] = table[row] == 1
+	}
+	return evaluated, nil
+}
+
+// simulationOrder returns the wires driven by gates in topological order, including the gates no output
+// depends on, and checks that every output is driven
+func simulationOrder(circuit map[string][]string, inputs, outputs []string) ([]string, error) {
+	wires := make([]string, 0, len(circuit))
+	for wire := range circuit {
+		wires = append(wires, wire)
+	}
+	sort.Strings(wires)
+
+	var gates []string
+	for _, wire := range topoOrder(circuit, inputs, append(append([]string(nil), outputs...), wires...)) {
+		if _, isInput := find(inputs, wire); isInput {
+			continue
+		}
+		if len(circuit[wire]) == 0 {
+			if _, isOutput := find(outputs, wire); isOutput {
+				return nil, fmt.Errorf("output %s has no driver", wire)
+			}
+			continue // Undriven wires only matter to the gates reading them
+		}
+		gates = append(gates, wire)
+	}
+	return gates, nil
+}
+
+// simulateCircuitWords evaluates a circuit on integer inputs and returns its outputs as integers. Inputs and
+// outputs are grouped into buses by busGroups: words holds the integer of every input bus, encoded into its bits
+// with wireValues (x -> x_0, x_1, ...), and the result holds the integer of every output bus.
+func simulateCircuitWords(circuit map[string][]string, inputs, outputs []string, words map[string]*big.Int) (map[string]*big.Int, error) {
+	values, err := encodeWords(inputs, words)
+	if err != nil {
+		return nil, err
+	}
+	evaluated, err := simulateCircuit(circuit, inputs, outputs, values)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]*big.Int)
+	prefixes, groups := busGroups(outputs)
+	for _, prefix := range prefixes {
+		word := new(big.Int)
+		for _, wire := range groups[prefix] {
+			_, index, _ := splitBitName(wire)
+			if evaluated[wire] {
+				word.SetBit(word, index, 1)
+			}
+		}
+		results[prefix] = word
+	}
+	return results, nil
+}
+
+// encodeWords returns the values of the input wires holding the integer of every input bus, as
+// simulateCircuitWords encodes them
+func encodeWords(inputs []string, words map[string]*big.Int) (map[string]bool, error) {
+	values := make(map[string]bool, len(inputs))
+	prefixes, groups := busGroups(inputs)
+	for _, prefix := range prefixes {
+		word, exists := words[prefix]
+		if !exists {
+			return nil, fmt.Errorf("no value for input %s", prefix)
+		}
+		bits := 0
+		for _, wire := range groups[prefix] {
+			_, index, _ := splitBitName(wire)
+			bits = max(bits, index+1)
+		}
+		encoded := wireValues(prefix, word, bits)
+		for _, wire := range groups[prefix] {
+			if bit, exists := encoded[wire]; exists {
+				values[wire] = bit.Sign() != 0
+			} else { // Named without a bit index (a single bit input), or with a padded one
+				_, index, _ := splitBitName(wire)
+				values[wire] = word.Bit(index) == 1
+			}
+		}
+	}
+	return values, nil
+}
+
+// ______ Bit-Sliced Simulation ______
+
+// simulateCircuitSliced evaluates a circuit on many input vectors at once: bit j of word i of a wire's lanes is
+// the wire's value in vector 64*i + j, so every gate is evaluated on 64 vectors per machine word. Every input
+// needs the same, non-zero number of words. Returns the lanes of every wire like simulateCircuit returns values.
+func simulateCircuitSliced(circuit map[string][]string, inputs, outputs []string, lanes map[string][]uint64) (map[string][]uint64, error) {
+	evaluated := make(map[string][]uint64, len(circuit))
+	words := -1
+	for _, input := range inputs {
+		value, exists := lanes[input]
+		if !exists {
+			return nil, fmt.Errorf("no value for input %s", input)
+		}
+		if words >= 0 && len(value) != words {
+			return nil, fmt.Errorf("input %s has %d words of lanes instead of %d", input, len(value), words)
+		}
+		words = len(value)
+		evaluated[input] = value
+	}
+	switch words {
+	case 0:
+		return nil, fmt.Errorf("the inputs have no lanes")
+	case -1:
+		words = 1 // A circuit without inputs has a single value
+	}
+
+	gates, err := simulationOrder(circuit, inputs, outputs)
+	if err != nil {
+		return nil, err
+	}
+	for _, wire := range gates {
+		gate := circuit[wire]
+		table, err := gateTruthTable(gate[0], len(gate)-1)
+		if err != nil {
+			return nil, fmt.Errorf("wire %s: %v", wire, err)
+		}
+		ins := make([][]uint64, len(gate)-1)
+		for i, in := range gate[1:] {
+			var known bool
+			if ins[i], known = evaluated[in]; !known {
+				return nil, fmt.Errorf("wire %s reads %s, which is undriven or on a combinational cycle", wire, in)
+			}
+		}
+
+		out := make([]uint64, words)
+		for w := range out {
+			out[w] = slicedGate(gate[0], table, ins, w)
+		}
+		evaluated[wire] = out
+	}
+	return evaluated, nil
+}
+
+// slicedGate evaluates a gate on word w of the lanes of its inputs
+func slicedGate(gate string, table []int, ins [][]uint64, w int) uint64 {
+	switch gate {
+	case "and":
+		return ins[0][w] & ins[1][w]
+	case "or":
+		return ins[0][w] | ins[1][w]
+	case "xor":
+		return ins[0][w] ^ ins[1][w]
+	case "nand":
+		return ^(ins[0][w] & ins[1][w])
+	case "nor":
+		return ^(ins[0][w] | ins[1][w])
+	case "xnor":
+		return ^(ins[0][w] ^ ins[1][w])
+	case "andnot":
+		return ins[0][w] &^ ins[1][w]
+	case "ornot":
+		return ins[0][w] | ^ins[1][w]
+	case "not":
+		return ^ins[0][w]
+	case "buf":
+		return ins[0][w]
+	}
+
+	// Any other table: the OR of its rows (minterms) with output 1
+	var out uint64
+	for row, value := range table {
+		if value == 0 {
+			continue
+		}
+		minterm := ^uint64(0)
+		for i, in := range ins { // The first input is the most significant bit of the row
+			if row&inputBit(len(ins), i) != 0 {
+				minterm &= in[w]
+			} else {
+				minterm &^= in[w]
+			}
+		}
+		out |= minterm
+	}
+	return out
+}
+
+// Most inputs exhaustiveLanes enumerates, 2^24 vectors taking 2 MiB of lanes per input
+const maxExhaustiveInputs = 24
+
+// exhaustiveLanes returns the lanes of all 2^n combinations of values of n inputs, for simulateCircuitSliced:
+// vector v gives input i the value of bit i of v
+func exhaustiveLanes(inputs []string) (map[string][]uint64, error) {
+	if len(inputs) > maxExhaustiveInputs {
+		return nil, fmt.Errorf("%d inputs are too many to enumerate, at most %d", len(inputs), maxExhaustiveInputs)
+	}
+	vectors := 1 << uint(len(inputs))
+	lanes := make(map[string][]uint64, len(inputs))
+	for i, input := range inputs {
+		lanes[input] = make([]uint64, (vectors+63)/64)
+		for v := 0; v < vectors; v++ {
+			if v>>uint(i)&1 == 1 {
+				lanes[input][v/64] |= 1 << uint(v%64)
+			}
+		}
+	}
+	return lanes, nil
+}
+
+// randomLanes returns words*64 pseudorandom input vectors for simulateCircuitSliced, the same ones for the same seed
+func randomLanes(inputs []string, words int, seed int64) map[string][]uint64 {
+	source := rand.New(rand.NewSource(seed))
+	lanes := make(map[string][]uint64, len(inputs))
+	for _, input := range inputs {
+		lanes[input] = make([]uint64, words)
+		for w := range lanes[input] {
+			lanes[input][w] = source.Uint64()
+		}
+	}
+	return lanes
+}
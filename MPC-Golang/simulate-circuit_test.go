@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSimulateCircuitSliced(t *testing.T) {
+	b := NewCircuitBuilder()
+	x := b.InputWord("x", 3)
+	for _, gate := range []string{"and", "or", "xor", "nand", "nor", "xnor", "andnot", "ornot"} {
+		b.Output(gate, b.Gate(gate, x[0], x[1]))
+	}
+	b.OutputWord("out", []Wire{b.Gate("lut_01101000", x[0], x[1], x[2]), b.Not(x[2]), b.Gate("buf", x[1]), b.Const(true)})
+	circuit, inputs, outputs := b.Build()
+
+	lanes, err := exhaustiveLanes(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sliced, err := simulateCircuitSliced(circuit, inputs, outputs, lanes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 0; v < 8; v++ {
+		values := map[string]bool{"x_0": v&1 == 1, "x_1": v&2 == 2, "x_2": v&4 == 4}
+		want, err := simulateCircuit(circuit, inputs, outputs, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for wire, value := range want {
+			if got := sliced[wire][0]>>uint(v)&1 == 1; got != value {
+				t.Errorf("%s is %v for %v, want %v", wire, got, values, value)
+			}
+		}
+	}
+
+	if _, err := simulateCircuitSliced(circuit, inputs, outputs, randomLanes(inputs, 0, 1)); err == nil {
+		t.Error("no error for empty lanes")
+	}
+	lanes["x_1"] = append(lanes["x_1"], 0)
+	if _, err := simulateCircuitSliced(circuit, inputs, outputs, lanes); err == nil {
+		t.Error("no error for lanes of different lengths")
+	}
+	if _, err := exhaustiveLanes(make([]string, maxExhaustiveInputs+1)); err == nil {
+		t.Error("enumerated too many inputs")
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"math/big"
 )
 // ____________________________ Symmetric Cryptography: AES-GCM ____________________________________
-func symmetricEnc(key []byte, x *big.Int) ([]byte, []byte, error) {
+func symmetricEnc(key []byte, plaintext []byte) ([]byte, []byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, nil, err
@@ -25,7 +25,6 @@ func symmetricEnc(key []byte, x *big.Int) ([]byte, []byte, error) {
 		return nil, nil, err
 	}
 
-	plaintext := x.Bytes()
 	ciphertext := aesGCM.Seal(nil, nonce, plaintext, nil) // The tag is included in ciphertext
 
 	return ciphertext, nonce, nil
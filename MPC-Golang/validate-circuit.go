@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ___________________________________________ Circuit Validation _____________________________
+
+// ValidateCircuit checks that a circuit can be garbled and evaluated: every gate has the arity of its type,
+// every wire read is an input or driven by exactly one gate, every output is driven and there are no
+// combinational cycles. The returned error is an errorList with one line per problem. Inputs nothing reads
+// are allowed, as the optimizer passes leave them behind; unusedInputs lists them.
+func ValidateCircuit(circuit map[string][]string, inputs, outputs []string) error {
+	var errs errorList
+
+	isInput := make(map[string]bool)
+	for _, input := range inputs {
+		if isInput[input] {
+			errs.add(fmt.Errorf("input %s is declared twice", input))
+		}
+		isInput[input] = true
+		if len(circuit[input]) > 0 {
+			errs.add(fmt.Errorf("input %s has multiple drivers: it is driven by a %s gate", input, circuit[input][0]))
+		}
+	}
+
+	wires := make([]string, 0, len(circuit))
+	for wire := range circuit {
+		wires = append(wires, wire)
+	}
+	sort.Strings(wires) // Report in a stable order
+
+	// Gate arity, and wires read without being driven
+	reported := make(map[string]bool)
+	for _, wire := range wires {
+		gate := circuit[wire]
+		if len(gate) == 0 {
+			continue
+		}
+		if _, err := gateTruthTable(gate[0], len(gate)-1); err != nil {
+			errs.add(fmt.Errorf("wire %s: %v", wire, err))
+		}
+		for _, in := range gate[1:] {
+			if isInput[in] || len(circuit[in]) > 0 || reported[in] {
+				continue
+			}
+			reported[in] = true
+			if _, declared := circuit[in]; declared {
+				errs.add(fmt.Errorf("wire %s read by %s is never driven", in, wire))
+			} else {
+				errs.add(fmt.Errorf("wire %s read by %s is never declared", in, wire))
+			}
+		}
+	}
+
+	for _, output := range outputs {
+		if !isInput[output] && len(circuit[output]) == 0 {
+			errs.add(fmt.Errorf("output %s has no driver", output))
+		}
+	}
+	for _, cycle := range circuitCycles(circuit, wires) {
+		errs.add(fmt.Errorf("combinational cycle %s", strings.Join(cycle, " -> ")))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// unusedInputs returns the inputs that no gate reads and that are not outputs either
+func unusedInputs(circuit map[string][]string, inputs, outputs []string) []string {
+	used := make(map[string]bool)
+	for _, gate := range circuit {
+		if len(gate) > 0 {
+			for _, in := range gate[1:] {
+				used[in] = true
+			}
+		}
+	}
+	for _, output := range outputs {
+		used[output] = true
+	}
+	var unused []string
+	for _, input := range inputs {
+		if !used[input] {
+			unused = append(unused, input)
+		}
+	}
+	return unused
+}
+
+// circuitCycles returns the combinational cycles found by a depth-first search through the gate inputs,
+// each as the wires along it with the first wire repeated at the end
+func circuitCycles(circuit map[string][]string, wires []string) [][]string {
+	const (
+		unvisited = iota
+		onPath    // Being visited, on the current search path
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var cycles [][]string
+
+	var visit func(wire string)
+	visit = func(wire string) {
+		switch state[wire] {
+		case done:
+			return
+		case onPath: // Back to a wire on the path: the path from there on is a cycle
+			start := len(path) - 1
+			for path[start] != wire {
+				start--
+			}
+			cycle := append([]string(nil), path[start:]...)
+			cycles = append(cycles, append(cycle, wire))
+			return
+		}
+		state[wire] = onPath
+		path = append(path, wire)
+		if gate := circuit[wire]; len(gate) > 0 {
+			for _, in := range gate[1:] {
+				visit(in)
+			}
+		}
+		path = path[:len(path)-1]
+		state[wire] = done
+	}
+
+	for _, wire := range wires {
+		visit(wire)
+	}
+	return cycles
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCircuit(t *testing.T) {
+	valid := map[string][]string{"a": nil, "b": nil, "t": {"xor", "a", "b"}, "o": {"and", "t", "a"}}
+	if err := ValidateCircuit(valid, []string{"a", "b"}, []string{"o"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		circuit map[string][]string
+		outputs []string
+		want    string
+	}{
+		{map[string][]string{"a": nil, "b": nil, "p": {"and", "a", "q"}, "q": {"or", "p", "b"}}, []string{"p"}, "combinational cycle p -> q -> p"},
+		{map[string][]string{"a": {"not", "b"}, "b": nil, "o": {"buf", "a"}}, []string{"o"}, "input a has multiple drivers"},
+		{map[string][]string{"a": nil, "b": nil, "o": {"and", "a", "u"}}, []string{"o"}, "wire u read by o is never declared"},
+		{map[string][]string{"a": nil, "b": nil, "u": nil, "o": {"and", "a", "u"}}, []string{"o"}, "wire u read by o is never driven"},
+		{map[string][]string{"a": nil, "b": nil, "o": nil}, []string{"o"}, "output o has no driver"},
+		{map[string][]string{"a": nil, "b": nil, "o": {"and", "a"}}, []string{"o"}, "wire o: gate and does not take 1 inputs"},
+	} {
+		err := ValidateCircuit(test.circuit, []string{"a", "b"}, test.outputs)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: error %v, want %q", test.circuit, err, test.want)
+		}
+	}
+}
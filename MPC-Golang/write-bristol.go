@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+)
+
+// ___________________________________________ Bristol Fashion Writer _____________________________
+
+// freshWire returns an unused wire name derived from base (base$1, base$2, ...)
+func freshWire(circuit map[string][]string, base string) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s$%d", base, i)
+		if _, exists := circuit[name]; !exists {
+			return name
+		}
+	}
+}
+
+// andXorBasis rewrites the gates of a circuit into and, xor, not, buf and constant gates, preferring
+// XORs over ANDs (a | b becomes a ^ b ^ (a & b)). The rewritten gates get fresh wires named after
+// the wire they drive; inputs and outputs keep their names.
+func andXorBasis(circuit map[string][]string, inputs, outputs []string) map[string][]string {
+	basis := make(map[string][]string, len(circuit))
+	for wire, gate := range circuit {
+		basis[wire] = gate
+	}
+
+	for _, wire := range topoOrder(circuit, inputs, outputs) {
+		gate := circuit[wire]
+		if len(gate) == 0 {
+			continue
+		}
+		fresh := func(g ...string) string {
+			name := freshWire(basis, wire)
+			basis[name] = g
+			return name
+		}
+		switch ins := gate[1:]; gate[0] {
+		case "nand": // ~(a & b)
+			basis[wire] = []string{"not", fresh("and", ins[0], ins[1])}
+		case "or": // a ^ b ^ (a & b)
+			basis[wire] = []string{"xor", fresh("xor", ins[0], ins[1]), fresh("and", ins[0], ins[1])}
+		case "nor": // ~(a ^ b ^ (a & b))
+			basis[wire] = []string{"not", fresh("xor", fresh("xor", ins[0], ins[1]), fresh("and", ins[0], ins[1]))}
+		case "xnor": // ~(a ^ b)
+			basis[wire] = []string{"not", fresh("xor", ins[0], ins[1])}
+		case "andnot": // a & ~b == a ^ (a & b)
+			basis[wire] = []string{"xor", ins[0], fresh("and", ins[0], ins[1])}
+		case "ornot": // a | ~b == ~(b & ~a) == ~(b ^ (a & b))
+			basis[wire] = []string{"not", fresh("xor", ins[1], fresh("and", ins[0], ins[1]))}
+		default:
+			if table, err := gateTruthTable(gate[0], len(ins)); err == nil && strings.HasPrefix(gate[0], "lut_") {
+				basis[wire] = anfGates(table, ins, fresh)
+			}
+		}
+	}
+	return basis
+}
+
+// anfGates rewrites a lookup table into its algebraic normal form, an XOR of ANDs of inputs
+// (x ^ y ^ x & y & z ^ 1), and returns the gate driving the result. Other gates are added with fresh.
+// Tables of up to three inputs get the fewest ANDs possible, factoring the products (at most two ANDs).
+func anfGates(table []int, ins []string, fresh func(g ...string) string) []string {
+	// Moebius transform: coefficient m says whether the product of the inputs in m is part of the sum
+	coefficients := append([]int(nil), table...)
+	for bit := 1; bit < len(coefficients); bit <<= 1 {
+		for m := range coefficients {
+			if m&bit != 0 {
+				coefficients[m] ^= coefficients[m^bit]
+			}
+		}
+	}
+	input := func(m int) string { // The input of a single bit monomial; the first input is the most significant bit
+		return ins[len(ins)-1-bits.TrailingZeros(uint(m))]
+	}
+
+	var terms []string
+	if len(ins) <= 3 {
+		terms = factoredTerms(coefficients, input, fresh)
+	} else {
+		products := make(map[int]string) // Monomial -> wire, sharing the smaller products
+		var product func(m int) string
+		product = func(m int) string {
+			if wire, exists := products[m]; exists {
+				return wire
+			}
+			low := m & -m
+			if m == low {
+				products[m] = input(m)
+			} else {
+				products[m] = fresh("and", product(m^low), input(low))
+			}
+			return products[m]
+		}
+		for m := 1; m < len(coefficients); m++ {
+			if coefficients[m] == 1 {
+				terms = append(terms, product(m))
+			}
+		}
+	}
+	return xorGates(terms, coefficients[0], fresh)
+}
+
+// factoredTerms returns the terms of the algebraic normal form of a function of up to three inputs a, b, c
+// (monomials 1, 2, 4), rewriting the products with as few ANDs as possible:
+//
+//	ab ^ ac            == a & (b ^ c)
+//	ab ^ ac ^ bc       == (a ^ c) & (b ^ c) ^ c
+//	abc ^ q_ab ab ^ q_ac ac ^ q_bc bc == ((a ^ q_bc) & (b ^ q_ac) ^ q_ac q_bc) & (c ^ q_ab) ^ q_ab q_ac a ^ q_ab q_bc b
+func factoredTerms(coefficients []int, input func(m int) string, fresh func(g ...string) string) []string {
+	coefficient := func(m int) bool { return m < len(coefficients) && coefficients[m] == 1 }
+	linear := make(map[int]bool) // Single input monomials of the sum
+	for m := 1; m < len(coefficients); m <<= 1 {
+		linear[m] = coefficient(m)
+	}
+	negate := func(wire string, negated bool) string {
+		if negated {
+			return fresh("not", wire)
+		}
+		return wire
+	}
+
+	var product string // The factored products, "" when there are none
+	var pairs [][2]int
+	for _, pair := range [][2]int{{1, 2}, {1, 4}, {2, 4}} {
+		if coefficient(pair[0] | pair[1]) {
+			pairs = append(pairs, pair)
+		}
+	}
+	switch {
+	case coefficient(7):
+		qab, qac, qbc := coefficient(3), coefficient(5), coefficient(6)
+		ab := fresh("and", negate(input(1), qbc), negate(input(2), qac))
+		product = fresh("and", negate(ab, qac && qbc), negate(input(4), qab))
+		linear[1] = linear[1] != (qab && qac)
+		linear[2] = linear[2] != (qab && qbc)
+	case len(pairs) == 1:
+		product = fresh("and", input(pairs[0][0]), input(pairs[0][1]))
+	case len(pairs) == 2: // The pairs share an input
+		first, second := pairs[0][0]|pairs[0][1], pairs[1][0]|pairs[1][1]
+		shared, others := first&second, first^second
+		product = fresh("and", input(shared), fresh("xor", input(others&-others), input(others&(others-1))))
+	case len(pairs) == 3:
+		product = fresh("and", fresh("xor", input(1), input(4)), fresh("xor", input(2), input(4)))
+		linear[4] = !linear[4]
+	}
+
+	var terms []string
+	if product != "" {
+		terms = append(terms, product)
+	}
+	for m := 1; m < len(coefficients); m <<= 1 {
+		if linear[m] {
+			terms = append(terms, input(m))
+		}
+	}
+	return terms
+}
+
+// xorGates returns the gate computing the XOR of the terms and the constant, adding the intermediate XORs with fresh
+func xorGates(terms []string, constant int, fresh func(g ...string) string) []string {
+	if len(terms) == 0 {
+		return []string{fmt.Sprintf("const_%d", constant)}
+	}
+	sum := terms[0]
+	for i, term := range terms[1:] {
+		if i == len(terms)-2 && constant == 0 {
+			return []string{"xor", sum, term}
+		}
+		sum = fresh("xor", sum, term)
+	}
+	if constant == 1 {
+		return []string{"not", sum}
+	}
+	return []string{"buf", sum}
+}
+
+// bristolGate is a single output gate line; wires that are outputs hold a placeholder until the end
+type bristolGate struct {
+	op  string
+	ins []int // The constant for EQ
+	out int
+}
+
+// writeBristol writes a circuit in the Bristol Fashion format read by parseBristol.
+// Gates are rewritten into AND/XOR/INV/EQW with andXorBasis, inputs and outputs are grouped into
+// values by their prefix (x_0, x_1, ... is one value) and constants are derived from the first input wire.
+func writeBristol(w io.Writer, circuit map[string][]string, inputs, outputs []string) error {
+	basis := andXorBasis(circuit, inputs, outputs)
+
+	inputPrefixes, inputGroups := busGroups(inputs)
+	outputPrefixes, outputGroups := busGroups(outputs)
+
+	// Input wires take the first numbers
+	id := make(map[string]int)
+	next := 0
+	for _, prefix := range inputPrefixes {
+		for _, wire := range inputGroups[prefix] {
+			id[wire] = next
+			next++
+		}
+	}
+
+	// Outputs take the last numbers, which are only known once all gates are numbered. Gates driving
+	// an output get the placeholder -slot (counting from 1); inputs and repeated outputs are copied there with EQW.
+	slots := make(map[string]int)
+	copies := make(map[int]string) // Slot -> input or repeated output wire
+	slot := 0
+	for _, prefix := range outputPrefixes {
+		for _, wire := range outputGroups[prefix] {
+			slot++
+			if _, isInput := id[wire]; isInput || slots[wire] != 0 {
+				copies[slot] = wire
+			} else {
+				slots[wire] = slot
+			}
+		}
+	}
+
+	var gates []bristolGate
+	newWire := func(wire string) int {
+		if slots[wire] != 0 {
+			id[wire] = -slots[wire]
+		} else {
+			id[wire] = next
+			next++
+		}
+		return id[wire]
+	}
+
+	for _, wire := range topoOrder(basis, inputs, outputs) {
+		if _, isInput := id[wire]; isInput {
+			continue
+		}
+		gate := basis[wire]
+		if len(gate) == 0 {
+			return fmt.Errorf("wire %s has no driver", wire)
+		}
+		ins := make([]int, len(gate)-1)
+		for i, in := range gate[1:] {
+			ins[i] = id[in]
+		}
+		switch gate[0] {
+		case "and":
+			gates = append(gates, bristolGate{"AND", ins, newWire(wire)})
+		case "xor":
+			gates = append(gates, bristolGate{"XOR", ins, newWire(wire)})
+		case "not":
+			gates = append(gates, bristolGate{"INV", ins, newWire(wire)})
+		case "buf":
+			gates = append(gates, bristolGate{"EQW", ins, newWire(wire)})
+		case "const_0", "const_1":
+			value := int(gate[0][6] - '0')
+			if len(inputs) == 0 { // Nothing to derive the constant from
+				gates = append(gates, bristolGate{"EQ", []int{value}, newWire(wire)})
+			} else if value == 0 { // x ^ x
+				gates = append(gates, bristolGate{"XOR", []int{0, 0}, newWire(wire)})
+			} else { // ~(x ^ x)
+				zero := next
+				next++
+				gates = append(gates, bristolGate{"XOR", []int{0, 0}, zero})
+				gates = append(gates, bristolGate{"INV", []int{zero}, newWire(wire)})
+			}
+		default:
+			return fmt.Errorf("unsupported gate %s", gate[0])
+		}
+	}
+	for slot := 1; slot <= len(outputs); slot++ {
+		if wire, isCopy := copies[slot]; isCopy {
+			gates = append(gates, bristolGate{"EQW", []int{id[wire]}, -slot})
+		}
+	}
+
+	// Resolve the output placeholders now that the number of wires is known
+	resolve := func(n int) int {
+		if n < 0 {
+			return next - n - 1
+		}
+		return n
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d %d\n", len(gates), next+len(outputs))
+	fmt.Fprint(bw, len(inputPrefixes))
+	for _, prefix := range inputPrefixes {
+		fmt.Fprint(bw, " ", len(inputGroups[prefix]))
+	}
+	fmt.Fprint(bw, "\n", len(outputPrefixes))
+	for _, prefix := range outputPrefixes {
+		fmt.Fprint(bw, " ", len(outputGroups[prefix]))
+	}
+	fmt.Fprint(bw, "\n\n")
+	for _, g := range gates {
+		fmt.Fprintf(bw, "%d 1", len(g.ins))
+		for _, in := range g.ins {
+			if g.op != "EQ" {
+				in = resolve(in)
+			}
+			fmt.Fprint(bw, " ", in)
+		}
+		fmt.Fprintf(bw, " %d %s\n", resolve(g.out), g.op)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// assertEquivalent checks that circuit b computes the outputs of circuit a, matching inputs and outputs by
+// position: on every input up to 12 inputs, on random ones above
+func assertEquivalent(t *testing.T, a map[string][]string, aInputs, aOutputs []string, b map[string][]string, bInputs, bOutputs []string) {
+	t.Helper()
+	if len(aInputs) != len(bInputs) || len(aOutputs) != len(bOutputs) {
+		t.Fatalf("%d inputs and %d outputs, want %d and %d", len(bInputs), len(bOutputs), len(aInputs), len(aOutputs))
+	}
+	rounds, random := 256, rand.New(rand.NewSource(1))
+	if len(aInputs) <= 12 {
+		rounds = 1 << uint(len(aInputs))
+	}
+	for round := 0; round < rounds; round++ {
+		aValues, bValues := make(map[string]bool), make(map[string]bool)
+		for i := range aInputs {
+			value := random.Intn(2) == 1
+			if len(aInputs) <= 12 {
+				value = round>>uint(i)&1 == 1
+			}
+			aValues[aInputs[i]], bValues[bInputs[i]] = value, value
+		}
+		aGot, err := simulateCircuit(a, aInputs, aOutputs, aValues)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bGot, err := simulateCircuit(b, bInputs, bOutputs, bValues)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range aOutputs {
+			if aGot[aOutputs[i]] != bGot[bOutputs[i]] {
+				t.Fatalf("output %s is %v for %v, want %v", bOutputs[i], bGot[bOutputs[i]], bValues, aGot[aOutputs[i]])
+			}
+		}
+	}
+}
+
+// Function that returns wires in the order writeBristol numbers them: grouped into values by busGroups
+func bristolOrder(wires []string) []string {
+	prefixes, groups := busGroups(wires)
+	var ordered []string
+	for _, prefix := range prefixes {
+		ordered = append(ordered, groups[prefix]...)
+	}
+	return ordered
+}
+
+func TestWriteBristolRoundTrip(t *testing.T) {
+	b := NewCircuitBuilder()
+	a, key := b.InputWord("a", 3), b.InputWord("key", 2)
+	b.Output("flag", b.Gate("lut_10010110", a[0], a[1], key[0]))
+	b.OutputWord("mixed", []Wire{
+		b.Or(a[2], key[1]),
+		b.Nand(a[0], key[1]),
+		b.Gate("xnor", a[1], a[2]),
+		b.Gate("andnot", key[0], a[1]),
+		b.Gate("ornot", a[0], key[0]),
+		b.Const(true),
+		key[1], // Outputs copied from inputs
+	})
+	circuit, inputs, outputs := b.Build()
+
+	var buf bytes.Buffer
+	if err := writeBristol(&buf, circuit, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	read, readInputs, readOutputs, err := readBristol(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Inputs are renamed x_, y_, ... and outputs out<i>_, in the order of their values
+	assertEquivalent(t, circuit, bristolOrder(inputs), bristolOrder(outputs), read, readInputs, readOutputs)
+}
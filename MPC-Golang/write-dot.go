@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ___________________________________________ Graphviz Writer _____________________________
+// Writes a circuit as a Graphviz digraph with a node per wire, labelled with its name and gate type, and an edge
+// from every gate input to the gate:
+//
+//	digraph circuit {
+//	  rankdir=LR;
+//	  "x_0" [label="x_0", shape=box, style=filled, fillcolor=lightblue];
+//	  "_000_" [label="_000_\nnor"];
+//	  "out" [label="out\nandnot", shape=doubleoctagon];
+//	  "x_1" -> "_000_";
+//	  "x_0" -> "out" [label=0];
+//	  "_000_" -> "out" [label=1];
+//	}
+//
+// Inputs are boxes filled by owner: x_ (Merlin, the garbler) blue, y_ (Arthur, the evaluator) orange, others
+// grey. Outputs are double octagons. The inputs of gates that are not commutative (andnot, lut_...) are
+// numbered. Render with: dot -Tsvg circuit.dot > circuit.svg
+
+// Fill colours of input wires by owner prefix, see splitBitName
+var dotInputColors = map[string]string{"x": "lightblue", "y": "lightsalmon"}
+
+// dotQuote returns s as a DOT string, keeping \n in labels for line breaks
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// writeDot writes a circuit as a Graphviz digraph. If values is not nil, such as the result of simulateCircuit,
+// every wire with a value shows it in its label and the edges of wires carrying a 1 are drawn in red.
+func writeDot(w io.Writer, circuit map[string][]string, inputs, outputs []string, values map[string]bool) error {
+	isInput := make(map[string]bool)
+	for _, input := range inputs {
+		isInput[input] = true
+	}
+	isOutput := make(map[string]bool)
+	for _, output := range outputs {
+		isOutput[output] = true
+	}
+
+	wires := append(append([]string(nil), inputs...), outputs...)
+	var internal []string
+	for wire := range circuit {
+		internal = append(internal, wire)
+	}
+	sort.Strings(internal)
+	wires = append(wires, internal...)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph circuit {\n  rankdir=LR;\n")
+	order := topoOrder(circuit, inputs, wires)
+	for _, wire := range order {
+		label := wire
+		gate := circuit[wire]
+		if !isInput[wire] && len(gate) > 0 {
+			label += "\n" + gate[0]
+		}
+		if value, known := values[wire]; known {
+			label += fmt.Sprintf(" = %d", boolToInt(value))
+		}
+		var attributes []string
+		switch {
+		case isInput[wire]:
+			party, _, _ := splitBitName(wire)
+			color, owned := dotInputColors[party]
+			if !owned {
+				color = "lightgrey"
+			}
+			attributes = append(attributes, "shape=box", "style=filled", "fillcolor="+color)
+		case isOutput[wire]:
+			attributes = append(attributes, "shape=doubleoctagon")
+		case len(gate) == 0:
+			attributes = append(attributes, "style=dashed") // Read but never driven
+		}
+		fmt.Fprintf(bw, "  %s [%s];\n", dotQuote(wire), strings.Join(append([]string{"label=" + dotQuote(label)}, attributes...), ", "))
+	}
+
+	for _, wire := range order {
+		gate := circuit[wire]
+		if isInput[wire] || len(gate) == 0 {
+			continue
+		}
+		numbered := len(gate) > 2 && !commutativeGates[gate[0]]
+		for i, in := range gate[1:] {
+			var attributes []string
+			if numbered {
+				attributes = append(attributes, fmt.Sprintf("label=%d", i))
+			}
+			if values[in] {
+				attributes = append(attributes, "color=red")
+			}
+			fmt.Fprintf(bw, "  %s -> %s", dotQuote(in), dotQuote(wire))
+			if len(attributes) > 0 {
+				fmt.Fprintf(bw, " [%s]", strings.Join(attributes, ", "))
+			}
+			bw.WriteString(";\n")
+		}
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// Function that returns 1 for true and 0 for false
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
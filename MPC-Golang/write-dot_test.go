@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestWriteDot(t *testing.T) {
+	circuit := map[string][]string{
+		"x_0": nil, "x_1": nil, "y_0": nil,
+		"t":   {"xor", "x_0", "x_1"},
+		"out": {"andnot", "t", "y_0"},
+	}
+	inputs, outputs := []string{"x_0", "x_1", "y_0"}, []string{"out"}
+	values, err := encodeWords(inputs, map[string]*big.Int{"x": big.NewInt(1), "y": big.NewInt(0)}) // As with -set x=1 -set y=0
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values, err = simulateCircuit(circuit, inputs, outputs, values); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeDot(&buf, circuit, inputs, outputs, values); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	if !strings.HasPrefix(text, "digraph circuit {\n") || !strings.HasSuffix(text, "}\n") {
+		t.Fatalf("not a digraph:\n%s", text)
+	}
+	nodes, edges := 0, 0
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.Contains(line, " -> "):
+			edges++
+		case strings.Contains(line, "[label="):
+			nodes++
+		}
+	}
+	if nodes != 5 || edges != 4 {
+		t.Errorf("%d nodes and %d edges, want 5 and 4:\n%s", nodes, edges, text)
+	}
+	for _, want := range []string{
+		`"x_0" [label="x_0 = 1", shape=box, style=filled, fillcolor=lightblue];`,
+		`"t" [label="t\nxor = 1"];`,
+		`"out" [label="out\nandnot = 1", shape=doubleoctagon];`,
+		`"t" -> "out" [label=0, color=red];`,
+		`"y_0" -> "out" [label=1];`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %s in\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ___________________________________________ Verilog Netlist Writer _____________________________
+// Writes a circuit as flat structural Verilog in the style of the Yosys netlist out.v, which parseVerilog
+// reads back with the same inputs and outputs: the module header lists the ports, every wire is declared on
+// its own line (ports first, after their input or output declaration) and every gate becomes an assign statement:
+//
+//	module test(x_0, x_1, out);
+//	  input x_0;
+//	  wire x_0;
+//	  ...
+//	  output out;
+//	  wire out;
+//	  wire _000_;
+//	  assign _000_ = ~(x_1 | x_0);
+//	  assign out = x_0 & ~(_000_);
+//	endmodule
+//
+// Wire names that are not Verilog identifiers (instance wires such as a1.t1) are written as escaped identifiers.
+
+// Expressions of the named gates, with %[1]s, %[2]s standing for the inputs
+var verilogGateExprs = map[string]string{
+	"and":     "%[1]s & %[2]s",
+	"or":      "%[1]s | %[2]s",
+	"xor":     "%[1]s ^ %[2]s",
+	"nand":    "~(%[1]s & %[2]s)",
+	"nor":     "~(%[1]s | %[2]s)",
+	"xnor":    "~(%[1]s ^ %[2]s)",
+	"andnot":  "%[1]s & ~(%[2]s)",
+	"ornot":   "%[1]s | ~(%[2]s)",
+	"not":     "~%[1]s",
+	"buf":     "%[1]s",
+	"const_0": "1'h0",
+	"const_1": "1'h1",
+}
+
+// verilogName returns a wire name as a Verilog identifier, escaping it (\a1.t1 ) unless it is a plain one
+func verilogName(wire string) string {
+	_, primitive := gatePrimitives[wire]
+	plain := wire != "" && isIdentStart(rune(wire[0])) && !verilogKeywords[wire] && !primitive
+	for _, c := range wire {
+		if !isIdentPart(c) {
+			plain = false
+		}
+	}
+	if plain {
+		return wire
+	}
+	return "\\" + wire + " "
+}
+
+// verilogGateExpr returns the right-hand side of the assign statement computing a gate
+func verilogGateExpr(gate []string) (string, error) {
+	table, err := gateTruthTable(gate[0], len(gate)-1)
+	if err != nil {
+		return "", err
+	}
+	ins := make([]interface{}, len(gate)-1)
+	for i, in := range gate[1:] {
+		ins[i] = verilogName(in)
+	}
+	if format, found := verilogGateExprs[gate[0]]; found {
+		return fmt.Sprintf(format, ins...), nil
+	}
+
+	// Lookup tables become a sum of the rows (minterms) where the output is 1
+	var minterms []string
+	for row, out := range table {
+		if out == 0 {
+			continue
+		}
+		literals := make([]string, len(ins))
+		for i, in := range ins { // The first input is the most significant bit of the row
+			if (row>>(len(ins)-1-i))&1 == 1 {
+				literals[i] = in.(string)
+			} else {
+				literals[i] = "~" + in.(string)
+			}
+		}
+		minterms = append(minterms, "("+strings.Join(literals, " & ")+")")
+	}
+	if len(minterms) == 0 {
+		return "1'h0", nil
+	}
+	return strings.Join(minterms, " | "), nil
+}
+
+// writeVerilog writes a circuit as the structural Verilog module named module
+func writeVerilog(w io.Writer, module string, circuit map[string][]string, inputs, outputs []string) error {
+	direction := make(map[string]string)
+	var ports []string
+	for _, input := range inputs {
+		if direction[input] == "" {
+			ports = append(ports, input)
+		}
+		direction[input] = "input"
+	}
+	for _, output := range outputs {
+		switch direction[output] {
+		case "input":
+			return fmt.Errorf("wire %s is both an input and an output", output)
+		case "":
+			ports = append(ports, output)
+		}
+		direction[output] = "output"
+	}
+
+	// Internal wires sorted by name, after the ports whose declaration order parseVerilog keeps
+	var internal []string
+	for wire := range circuit {
+		if direction[wire] == "" {
+			internal = append(internal, wire)
+		}
+	}
+	sort.Strings(internal)
+	wires := append(append([]string(nil), ports...), internal...)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "module %s(", verilogName(module))
+	lineLength := 0
+	for i, port := range ports {
+		name := verilogName(port)
+		if i > 0 {
+			bw.WriteString(",")
+			if lineLength+len(name) > 120 { // Wrap long port lists the way Yosys does, after the comma
+				bw.WriteString("\n")
+				lineLength = 0
+			} else {
+				bw.WriteString(" ")
+			}
+		}
+		bw.WriteString(name)
+		lineLength += len(name) + 2
+	}
+	bw.WriteString(");\n")
+
+	for _, wire := range wires {
+		if dir := direction[wire]; dir != "" {
+			fmt.Fprintf(bw, "  %s %s;\n", dir, verilogName(wire))
+		}
+		fmt.Fprintf(bw, "  wire %s;\n", verilogName(wire))
+	}
+
+	// Gates in topological order, starting from every wire so that unused gates are kept as well
+	for _, wire := range topoOrder(circuit, inputs, wires) {
+		gate := circuit[wire]
+		if len(gate) == 0 || direction[wire] == "input" {
+			continue
+		}
+		expr, err := verilogGateExpr(gate)
+		if err != nil {
+			return fmt.Errorf("wire %s: %v", wire, err)
+		}
+		fmt.Fprintf(bw, "  assign %s = %s;\n", verilogName(wire), expr)
+	}
+	bw.WriteString("endmodule\n")
+	return bw.Flush()
+}
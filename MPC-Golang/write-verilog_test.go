@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteVerilogRoundTrip(t *testing.T) {
+	b := NewCircuitBuilder()
+	and, wire, dotted := b.Input("and"), b.Input("wire"), b.Input("a1.s")
+	x := b.InputWord("x", 30) // Enough ports to wrap the port list
+	b.Output("module", b.Gate("lut_10010110", and, wire, dotted))
+	b.Output("not", b.Gate("andnot", wire, x[0]))
+	b.Output("out", b.Or(b.XorAll(x), b.Gate("xnor", and, x[29])))
+	circuit, inputs, outputs := b.Build()
+
+	var buf bytes.Buffer
+	if err := writeVerilog(&buf, "round_trip", circuit, inputs, outputs); err != nil {
+		t.Fatal(err)
+	}
+	if header := buf.String()[:strings.Index(buf.String(), ";")]; !strings.Contains(header, ",\n") {
+		t.Errorf("port list of %d ports is not wrapped:\n%s", len(inputs)+len(outputs), header)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), ",") {
+			t.Errorf("line starts with a comma: %q", line)
+		}
+	}
+	read, readInputs, readOutputs, err := parseVerilog(writeTestFile(t, "round_trip.v", buf.String()))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(readInputs, inputs) || !reflect.DeepEqual(readOutputs, outputs) {
+		t.Errorf("ports %v %v, want %v %v", readInputs, readOutputs, inputs, outputs)
+	}
+	assertEquivalent(t, circuit, inputs, outputs, read, readInputs, readOutputs)
+}